@@ -0,0 +1,172 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+//Nicks are grouped into persons so statistics can be aggregated over everything someone has been called. A person is
+//named after one of its nicks; nicks that were never merged are persons of their own.
+
+var aliasMutex sync.Mutex //serializes changes to groups
+
+//aliasKey returns the hash of lowercased nick -> person for nicks that are merged with others
+func aliasKey() string {
+	return keyPrefix + "aliases:" + network()
+}
+
+//personKey returns the set of lowercased nicks belonging to person
+func personKey(person string) string {
+	return keyPrefix + "person:" + network() + ":" + person
+}
+
+//maskKey returns the hash of lowercased nick -> user@host it last spoke from
+func maskKey() string {
+	return keyPrefix + "masks:" + network()
+}
+
+//personOf returns the person nick belongs to
+func personOf(nick string) string {
+	nick = ircLower(nick)
+	person, found, err := db.HGet(aliasKey(), nick)
+	if err != nil || !found {
+		return nick
+	}
+	return person
+}
+
+//personNicks returns every nick of the person nick belongs to, sorted
+func personNicks(nick string) []string {
+	person := personOf(nick)
+	nicks, err := db.SMembers(personKey(person))
+	if err != nil || len(nicks) == 0 {
+		return []string{person}
+	}
+	sort.Strings(nicks)
+	return nicks
+}
+
+//aliasMap returns lowercased nick -> person for every merged nick
+func aliasMap() map[string]string {
+	aliases, err := db.HGetAll(aliasKey())
+	if err != nil {
+		return nil
+	}
+	return aliases
+}
+
+//linkNicks merges the person b belongs to into the person a belongs to. It returns false if they were already the
+//same person.
+func linkNicks(a, b string) (bool, error) {
+	aliasMutex.Lock()
+	defer aliasMutex.Unlock()
+	personA, personB := personOf(a), personOf(b)
+	if personA == personB {
+		return false, nil
+	}
+	moving, err := db.SMembers(personKey(personB))
+	if err != nil {
+		return false, err
+	}
+	if len(moving) == 0 {
+		moving = []string{personB}
+	}
+	for _, nick := range append(moving, personA) {
+		if err := db.HSet(aliasKey(), nick, personA); err != nil {
+			return false, err
+		}
+		if err := db.SAdd(personKey(personA), nick); err != nil {
+			return false, err
+		}
+	}
+	if err := db.Del(personKey(personB)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+//unlinkNick makes nick a person of its own again. It returns false if it already was.
+func unlinkNick(nick string) (bool, error) {
+	aliasMutex.Lock()
+	defer aliasMutex.Unlock()
+	nick = ircLower(nick)
+	person := personOf(nick)
+	nicks, err := db.SMembers(personKey(person))
+	if err != nil {
+		return false, err
+	}
+	var rest []string
+	for _, other := range nicks {
+		if other != nick {
+			rest = append(rest, other)
+		}
+	}
+	if len(rest) == len(nicks) {
+		return false, nil
+	}
+	if err := db.HDel(aliasKey(), nick); err != nil {
+		return false, err
+	}
+	if err := db.Del(personKey(person)); err != nil {
+		return false, err
+	}
+	if len(rest) == 1 { //the last nick left is a person of its own too
+		return true, db.HDel(aliasKey(), rest[0])
+	}
+	if person == nick { //the group was named after nick, so rename it
+		sort.Strings(rest)
+		person = rest[0]
+	}
+	for _, other := range rest {
+		if err := db.HSet(aliasKey(), other, person); err != nil {
+			return false, err
+		}
+		if err := db.SAdd(personKey(person), other); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+//autoLinkable reports whether nick may be linked automatically on a NICK change, which isn't done for nicks matching
+//JSONconfig.UnlinkedNicks (["Guest*"] if not set) since they're shared by unrelated people
+func autoLinkable(nick string) bool {
+	patterns := config.UnlinkedNicks
+	if patterns == nil {
+		patterns = []string{"Guest*"}
+	}
+	for _, pattern := range patterns {
+		if wildcardMatch(ircLower(pattern), ircLower(nick)) {
+			return false
+		}
+	}
+	return true
+}
+
+//nickChanged links oldNick and newNick after a NICK change
+func nickChanged(oldNick, newNick string) {
+	if !autoLinkable(oldNick) || !autoLinkable(newNick) {
+		return
+	}
+	linkNicks(oldNick, newNick)
+}
+
+//recordMask records that nick is using userHost
+func recordMask(nick, userHost string) {
+	if userHost != "" {
+		db.HSet(maskKey(), ircLower(nick), userHost)
+	}
+}
+
+//sameUser reports whether nick was last seen using user@hostname, so whoever is using user@hostname may manage its
+//aliases
+func sameUser(nick, user, hostname string) bool {
+	userHost, found, err := db.HGet(maskKey(), ircLower(nick))
+	if err != nil || !found {
+		return false
+	}
+	parts := strings.SplitN(userHost, "@", 2)
+	return len(parts) == 2 && strings.EqualFold(strings.TrimLeft(parts[0], "~"), strings.TrimLeft(user, "~")) &&
+		strings.EqualFold(parts[1], hostname)
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+//LogConfig controls the bot's channel logs
+type LogConfig struct {
+	Disabled bool   //don't write channel logs
+	Dir      string //directory holding a subdirectory of logs per network ("logs")
+	Format   string //"irssi" (default) for irssi style text logs, or "json" for one JSON object per line
+}
+
+//logEvent is a single logged channel event
+type logEvent struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"` //privmsg, action, join, part, quit, nick, kick or topic
+	Nick     string    `json:"nick"`
+	UserHost string    `json:"userhost,omitempty"`
+	Target   string    `json:"target,omitempty"` //new nick for nick, kicked nick for kick
+	Text     string    `json:"text,omitempty"`   //message, reason or topic
+}
+
+//member is a user in a channel
+type member struct {
+	Nick     string
+	UserHost string //user@host, empty until learned from a JOIN or WHO reply
+}
+
+//chanLogFile is an open channel log
+type chanLogFile struct {
+	file    *os.File
+	lastDay string //date of the last line written, so day changes can be marked
+}
+
+var (
+	logMutex    sync.Mutex
+	logFiles    = make(map[string]*chanLogFile)      //path -> open log
+	members     = make(map[string]map[string]member) //lowercased channel -> lowercased nick -> member
+	networkName string                               //from RPL_ISUPPORT NETWORK if not configured
+
+	logPrivmsgRegex = regexp.MustCompile(`^:(\S+?)!(\S+?@\S+?) PRIVMSG (\S+) :(.*)`)
+	logJoinRegex    = regexp.MustCompile(`^:(\S+?)!(\S+?@\S+?) JOIN :?(\S+)`)
+	logPartRegex    = regexp.MustCompile(`^:(\S+?)!(\S+?@\S+?) PART (\S+)(?: :?(.*))?`)
+	logQuitRegex    = regexp.MustCompile(`^:(\S+?)!(\S+?@\S+?) QUIT(?: :?(.*))?`)
+	logNickRegex    = regexp.MustCompile(`^:(\S+?)!(\S+?@\S+?) NICK :?(\S+)`)
+	logKickRegex    = regexp.MustCompile(`^:(\S+?)!(\S+?@\S+?) KICK (\S+) (\S+)(?: :?(.*))?`)
+	logTopicRegex   = regexp.MustCompile(`^:(\S+?)!(\S+?@\S+?) TOPIC (\S+) :?(.*)`)
+	namesRegex      = regexp.MustCompile(`^:\S+ 353 \S+ \S+ (\S+) :(.*)`)
+
+	//irssi style log lines
+	irssiOpenedRegex  = regexp.MustCompile(`^--- Log opened \w+ (\w+ \d+ \d\d:\d\d:\d\d \d+)`)
+	irssiDayRegex     = regexp.MustCompile(`^--- Day changed \w+ (\w+ \d+ \d+)`)
+	irssiPrivmsgRegex = regexp.MustCompile(`^(\d\d):(\d\d) <[@\+%&~\s]?(\S+?)> ?(.*)`)
+	irssiActionRegex  = regexp.MustCompile(`^(\d\d):(\d\d)\s+\* (\S+) (.*)`)
+	irssiNoticeRegex  = regexp.MustCompile(`^(\d\d):(\d\d) -(\S+?):\S+- ?(.*)`)
+	irssiJoinRegex    = regexp.MustCompile(`^(\d\d):(\d\d) -!- (\S+) \[(\S*)\] has joined \S+`)
+	irssiPartRegex    = regexp.MustCompile(`^(\d\d):(\d\d) -!- (\S+) \[(\S*)\] has left \S+ \[(.*)\]`)
+	irssiQuitRegex    = regexp.MustCompile(`^(\d\d):(\d\d) -!- (\S+) \[(\S*)\] has quit \[(.*)\]`)
+	irssiNickRegex    = regexp.MustCompile(`^(\d\d):(\d\d) -!- (\S+) is now known as (\S+)`)
+	irssiKickRegex    = regexp.MustCompile(`^(\d\d):(\d\d) -!- (\S+) was kicked from \S+ by (\S+) \[(.*)\]`)
+	irssiTopicRegex   = regexp.MustCompile(`^(\d\d):(\d\d) -!- (\S+) changed the topic of \S+ to: (.*)`)
+)
+
+//network returns the name of the network the bot is connected to, used to separate logs of different networks
+func network() string {
+	if config.Network != "" {
+		return config.Network
+	}
+	if networkName != "" {
+		return networkName
+	}
+	//fall back to the second level domain of the server, chat.freenode.net -> freenode
+	parts := strings.Split(config.Server, ".")
+	if len(parts) >= 2 {
+		return strings.ToLower(parts[len(parts)-2])
+	}
+	return strings.ToLower(config.Server)
+}
+
+//safeName makes a network or channel name safe to use as a file name
+func safeName(name string) string {
+	name = strings.Replace(name, string(filepath.Separator), "_", -1)
+	name = strings.Replace(name, "/", "_", -1)
+	if name == "." || name == ".." {
+		name = "_"
+	}
+	return name
+}
+
+//logDir returns the directory holding the logs of the current network
+func logDir() string {
+	dir := config.Log.Dir
+	if dir == "" {
+		dir = "logs"
+	}
+	return filepath.Join(dir, safeName(network()))
+}
+
+//logPath returns the path of the log file for channel
+func logPath(channel string) string {
+	return filepath.Join(logDir(), safeName(ircLower(channel))+".log")
+}
+
+//writeLog appends event to channel's log
+func writeLog(channel string, event logEvent) {
+	if config.Log.Disabled || !isChannel(channel) || channelConfig(channel).NoLog {
+		return
+	}
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	path := logPath(channel)
+	logFile, found := logFiles[path]
+	if !found {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			log.Println(err.Error())
+			return
+		}
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Println(err.Error())
+			return
+		}
+		logFile = &chanLogFile{file: file}
+		logFiles[path] = logFile
+		if config.Log.Format != "json" {
+			fmt.Fprintf(file, "--- Log opened %s\n", event.Time.Format("Mon Jan 02 15:04:05 2006"))
+			logFile.lastDay = event.Time.Format("2006-01-02")
+		}
+	}
+
+	var line string
+	if config.Log.Format == "json" {
+		lineBytes, err := json.Marshal(event)
+		if err != nil {
+			log.Println(err.Error())
+			return
+		}
+		line = string(lineBytes)
+	} else {
+		if day := event.Time.Format("2006-01-02"); day != logFile.lastDay {
+			fmt.Fprintf(logFile.file, "--- Day changed %s\n", event.Time.Format("Mon Jan 02 2006"))
+			logFile.lastDay = day
+		}
+		line = formatIrssi(channel, event)
+	}
+	if _, err := fmt.Fprintln(logFile.file, line); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+//formatIrssi formats event as an irssi log line
+func formatIrssi(channel string, event logEvent) string {
+	line := event.Time.Format("15:04") + " "
+	switch event.Type {
+	case "privmsg":
+		line += "<" + event.Nick + "> " + event.Text
+	case "action":
+		line += " * " + event.Nick + " " + event.Text
+	case "notice":
+		line += "-" + event.Nick + ":" + channel + "- " + event.Text
+	case "join":
+		line += "-!- " + event.Nick + " [" + event.UserHost + "] has joined " + channel
+	case "part":
+		line += "-!- " + event.Nick + " [" + event.UserHost + "] has left " + channel + " [" + event.Text + "]"
+	case "quit":
+		line += "-!- " + event.Nick + " [" + event.UserHost + "] has quit [" + event.Text + "]"
+	case "nick":
+		line += "-!- " + event.Nick + " is now known as " + event.Target
+	case "kick":
+		line += "-!- " + event.Target + " was kicked from " + channel + " by " + event.Nick + " [" + event.Text + "]"
+	case "topic":
+		line += "-!- " + event.Nick + " changed the topic of " + channel + " to: " + event.Text
+	}
+	return line
+}
+
+//addMember records that nick is in channel. An empty userHost doesn't replace one already known.
+func addMember(channel, nick, userHost string) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	key := ircLower(channel)
+	if members[key] == nil {
+		members[key] = make(map[string]member)
+	}
+	if known, found := members[key][ircLower(nick)]; found && userHost == "" {
+		userHost = known.UserHost
+	}
+	members[key][ircLower(nick)] = member{nick, userHost}
+}
+
+//isMember reports whether nick, connected from userHost, is in channel
+func isMember(channel, nick, userHost string) bool {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	chanMember, found := members[ircLower(channel)][ircLower(nick)]
+	return found && chanMember.UserHost != "" && strings.EqualFold(chanMember.UserHost, userHost)
+}
+
+//removeMember records that nick left channel. If nick is the bot, the channel is forgotten.
+func removeMember(channel, nick string) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	if ircEqual(nick, config.Nick) {
+		delete(members, ircLower(channel))
+	} else if chanMembers, found := members[ircLower(channel)]; found {
+		delete(chanMembers, ircLower(nick))
+	}
+}
+
+//channelsOf returns the channels the bot shares with nick
+func channelsOf(nick string) []string {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	var channels []string
+	for channel, chanMembers := range members {
+		if _, found := chanMembers[ircLower(nick)]; found {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+//chanLog logs line if it's one of the events recorded in channel logs, and keeps track of channel membership so that
+//QUITs and NICKs can be logged to the right channels
+func chanLog(line string) {
+	now := time.Now()
+	if match := logPrivmsgRegex.FindStringSubmatch(line); match != nil {
+		event := logEvent{Time: now, Type: "privmsg", Nick: match[1], UserHost: match[2], Text: match[4]}
+		if strings.HasPrefix(event.Text, "\x01ACTION ") {
+			event.Type = "action"
+			event.Text = strings.TrimSuffix(strings.TrimPrefix(event.Text, "\x01ACTION "), "\x01")
+		} else if strings.HasPrefix(event.Text, "\x01") {
+			return //other CTCPs
+		} else if !channelConfig(match[3]).NoLog {
+			recordMask(match[1], match[2])
+			countMessage(match[3], match[1], now)
+			indexMessage(match[3], match[1], event.Text, now)
+		}
+		recordSeen(match[3], event)
+		writeLog(match[3], event)
+	} else if match := logJoinRegex.FindStringSubmatch(line); match != nil {
+		addMember(match[3], match[1], match[2])
+		event := logEvent{Time: now, Type: "join", Nick: match[1], UserHost: match[2]}
+		recordSeen(match[3], event)
+		writeLog(match[3], event)
+	} else if match := logPartRegex.FindStringSubmatch(line); match != nil {
+		event := logEvent{Time: now, Type: "part", Nick: match[1], UserHost: match[2], Text: match[4]}
+		recordSeen(match[3], event)
+		writeLog(match[3], event)
+		removeMember(match[3], match[1])
+	} else if match := logQuitRegex.FindStringSubmatch(line); match != nil {
+		event := logEvent{Time: now, Type: "quit", Nick: match[1], UserHost: match[2], Text: match[3]}
+		recordSeen("", event)
+		for _, channel := range channelsOf(match[1]) {
+			writeLog(channel, event)
+			removeMember(channel, match[1])
+		}
+	} else if match := logNickRegex.FindStringSubmatch(line); match != nil {
+		nickChanged(match[1], match[3])
+		recordMask(match[3], match[2])
+		event := logEvent{Time: now, Type: "nick", Nick: match[1], UserHost: match[2], Target: match[3]}
+		recordSeen("", event)
+		for _, channel := range channelsOf(match[1]) {
+			writeLog(channel, event)
+			removeMember(channel, match[1])
+			addMember(channel, match[3], match[2])
+		}
+		recordSeen("", logEvent{Time: now, Type: "renamed", Nick: match[3], UserHost: match[2], Target: match[1]})
+	} else if match := logKickRegex.FindStringSubmatch(line); match != nil {
+		event := logEvent{Time: now, Type: "kick", Nick: match[1], UserHost: match[2], Target: match[4], Text: match[5]}
+		recordSeen(match[3], event)
+		recordSeen(match[3], logEvent{Time: now, Type: "kicked", Nick: match[4], Target: match[1], Text: match[5]})
+		writeLog(match[3], event)
+		removeMember(match[3], match[4])
+	} else if match := logTopicRegex.FindStringSubmatch(line); match != nil {
+		writeLog(match[3], logEvent{Time: now, Type: "topic", Nick: match[1], UserHost: match[2], Text: match[4]})
+	} else if match := namesRegex.FindStringSubmatch(line); match != nil {
+		for _, nick := range strings.Fields(match[2]) {
+			addMember(match[1], strings.TrimLeft(nick, "@+%&~"), "")
+		}
+	}
+}
+
+//logOutgoing logs messages and notices the bot itself sends to channels
+func logOutgoing(line string) {
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) < 3 {
+		return
+	}
+	event := logEvent{Time: time.Now(), Nick: config.Nick, Text: strings.TrimPrefix(fields[2], ":")}
+	switch strings.ToUpper(fields[0]) {
+	case "PRIVMSG":
+		event.Type = "privmsg"
+	case "NOTICE":
+		event.Type = "notice"
+	default:
+		return
+	}
+	writeLog(fields[1], event)
+}
+
+//readLog calls fn for every event in channel's log, oldest first
+func readLog(channel string, fn func(logEvent)) error {
+	file, err := os.Open(logPath(channel))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.New("No log for " + channel)
+		}
+		return err
+	}
+	defer file.Close()
+	return parseIrssiOrJSON(file, time.Local, fn)
+}
+
+//parseIrssiOrJSON reads a log in either of the formats written by writeLog, calling fn for every event. Times in irssi
+//logs are interpreted in loc.
+func parseIrssiOrJSON(r io.Reader, loc *time.Location, fn func(logEvent)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var day time.Time
+	for scanner.Scan() {
+		if event, ok := parseLogLine(scanner.Text(), &day, loc); ok {
+			fn(event)
+		}
+	}
+	return scanner.Err()
+}
+
+//parseLogLine parses a single line of a log written by writeLog, in either format
+func parseLogLine(line string, day *time.Time, loc *time.Location) (logEvent, bool) {
+	if strings.HasPrefix(line, "{") {
+		var event logEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			return logEvent{}, false
+		}
+		return event, true
+	}
+	return parseIrssiLine(line, day, loc)
+}
+
+//parseIrssiLine parses a single line of an irssi log. day holds the date of the last "Log opened" or "Day
+//changed" line seen and is updated when such a line is parsed.
+func parseIrssiLine(line string, day *time.Time, loc *time.Location) (logEvent, bool) {
+	if match := irssiOpenedRegex.FindStringSubmatch(line); match != nil {
+		if t, err := time.ParseInLocation("Jan 02 15:04:05 2006", match[1], loc); err == nil {
+			*day = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		}
+		return logEvent{}, false
+	}
+	if match := irssiDayRegex.FindStringSubmatch(line); match != nil {
+		if t, err := time.ParseInLocation("Jan 02 2006", match[1], loc); err == nil {
+			*day = t
+		}
+		return logEvent{}, false
+	}
+	var event logEvent
+	var match []string
+	if match = irssiPrivmsgRegex.FindStringSubmatch(line); match != nil {
+		event = logEvent{Type: "privmsg", Nick: match[3], Text: match[4]}
+	} else if match = irssiActionRegex.FindStringSubmatch(line); match != nil {
+		event = logEvent{Type: "action", Nick: match[3], Text: match[4]}
+	} else if match = irssiNoticeRegex.FindStringSubmatch(line); match != nil {
+		event = logEvent{Type: "notice", Nick: match[3], Text: match[4]}
+	} else if match = irssiJoinRegex.FindStringSubmatch(line); match != nil {
+		event = logEvent{Type: "join", Nick: match[3], UserHost: match[4]}
+	} else if match = irssiPartRegex.FindStringSubmatch(line); match != nil {
+		event = logEvent{Type: "part", Nick: match[3], UserHost: match[4], Text: match[5]}
+	} else if match = irssiQuitRegex.FindStringSubmatch(line); match != nil {
+		event = logEvent{Type: "quit", Nick: match[3], UserHost: match[4], Text: match[5]}
+	} else if match = irssiNickRegex.FindStringSubmatch(line); match != nil {
+		event = logEvent{Type: "nick", Nick: match[3], Target: match[4]}
+	} else if match = irssiKickRegex.FindStringSubmatch(line); match != nil {
+		event = logEvent{Type: "kick", Nick: match[4], Target: match[3], Text: match[5]}
+	} else if match = irssiTopicRegex.FindStringSubmatch(line); match != nil {
+		event = logEvent{Type: "topic", Nick: match[3], Text: match[4]}
+	} else {
+		return logEvent{}, false
+	}
+	var hour, minute int
+	fmt.Sscanf(match[1]+" "+match[2], "%d %d", &hour, &minute)
+	event.Time = time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, loc)
+	return event, true
+}
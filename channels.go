@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+)
+
+//ChannelConfig holds the settings for a single channel. Channels without an entry in JSONconfig.ChannelSettings use
+//the entry for "*", and fields left empty there fall back to the defaults noted below.
+type ChannelConfig struct {
+	Prefixes      []string //strings that start a command, ["+"] if empty
+	Enabled       []string //if not empty, only these commands may be used
+	Disabled      []string //commands that may not be used
+	Reply         string   //"notice" (default) or "privmsg"
+	Quiet         bool     //only respond to commands; don't answer questions or suggest commands
+	Language      string   //language of canned replies, see translations
+	Private       bool     //only verified web users in the channel may read its logs on the web server
+	NoLog         bool     //privacy mode: don't log, count or index anything said in the channel
+	RetentionDays int      //days of the channel's log and search index to keep, 0 keeps everything
+}
+
+//channelConfig returns the settings for channel, falling back to the "*" entry for channels (and private messages)
+//that have none of their own
+func channelConfig(channel string) ChannelConfig {
+	var chanConf ChannelConfig
+	found := false
+	for name, conf := range config.ChannelSettings {
+		if ircEqual(name, channel) {
+			chanConf, found = conf, true
+			break
+		}
+	}
+	if !found {
+		chanConf = config.ChannelSettings["*"]
+	}
+	if len(chanConf.Prefixes) == 0 {
+		chanConf.Prefixes = []string{"+"}
+	}
+	if chanConf.Reply == "" {
+		chanConf.Reply = "notice"
+	}
+	return chanConf
+}
+
+//isChannel reports whether target is a channel name rather than a nick
+func isChannel(target string) bool {
+	return target != "" && strings.ContainsAny(target[:1], "#&+!")
+}
+
+//commandEnabled reports whether cmdName may be used in channel
+func commandEnabled(channel, cmdName string) bool {
+	chanConf := channelConfig(channel)
+	if len(chanConf.Enabled) > 0 && !containsFold(chanConf.Enabled, cmdName) {
+		return false
+	}
+	return !containsFold(chanConf.Disabled, cmdName)
+}
+
+//containsFold reports whether list contains s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+//replyPrefix returns the start of a reply to channel, either "NOTICE channel :" or "PRIVMSG channel :" depending on
+//the channel's Reply setting
+func replyPrefix(channel string) string {
+	if strings.EqualFold(channelConfig(channel).Reply, "privmsg") {
+		return "PRIVMSG " + channel + " :"
+	}
+	return "NOTICE " + channel + " :"
+}
+
+//commandLine extracts the command from a message sent to target. Messages addressed to the bot ("yaircb: cmd") or
+//starting with one of the channel's prefixes are commands, as is every private message to the bot. ok is false for
+//normal chatter. prefixed is true if the command was introduced by a prefix rather than by the bot's nick.
+func commandLine(target, text string) (cmdLine string, prefixed, ok bool) {
+	text = strings.TrimSpace(text)
+	if n := len(config.Nick); len(text) >= n && ircEqual(text[:n], config.Nick) {
+		rest := text[n:]
+		if rest == "" || !isWordChar(rest[0]) {
+			if rest != "" && rest[0] != ' ' {
+				rest = rest[1:] //drop the ':' or ',' after the nick
+			}
+			return strings.TrimSpace(rest), false, true
+		}
+	}
+	for _, prefix := range channelConfig(target).Prefixes {
+		if strings.HasPrefix(text, prefix) {
+			return strings.TrimSpace(text[len(prefix):]), true, true
+		}
+	}
+	if ircEqual(target, config.Nick) {
+		return text, false, true
+	}
+	return "", false, false
+}
+
+//isWordChar reports whether c would be matched by \w
+func isWordChar(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -1,13 +1,14 @@
 package main
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
-	"github.com/fzzy/radix/redis"
 	"io/ioutil"
 	"log"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"regexp"
@@ -16,7 +17,6 @@ import (
 	"time"
 )
 
-var cmdDb *redis.Client
 var helpStrings = map[string]string{
 	"help":      "Gives help about commands",
 	"source":    "Returns link to github repository",
@@ -25,12 +25,12 @@ var helpStrings = map[string]string{
 	"uptime":    "Returns output from exeuction of 'uptime' command",
 	"web":       "Returns link to home page of web server",
 	"login":     "Returns link to login on the web server",
-	"verify":    "Links IRC nick to web server user. Takes two arguments, web username, and PIN. Both provided on account page",
-	"verified":  "Returns whether or not user is verified with web username, supplied as only argument.",
+	"verify":    "Links IRC nick to web server user. Takes two arguments, web username, and PIN. Both provided on account page, the PIN expires after a while",
+	"verified":  "Returns whether or not user's host is one verified with web username, supplied as only argument.",
 	"commands":  "Lists available commands",
 	"kick":      "Kicks user with given reason. Takes two arguments, user and reason.",
-	"wc":        "Displays number of messages of a user in a channel. Takes one argument, user to query",
-	"top":       "Displays top n users by message count in channel. Takes one argument, number of users to show",
+	"wc":        "Displays number of messages of a user, counting nicks merged with theirs, in a channel. Takes the user to query and optionally a time window (today, yesterday, week, month or all)",
+	"top":       "Displays top n users by message count in channel. Takes the number of users to show and optionally a time window (today, yesterday, week, month or all)",
 	"footprint": "Displays resident memory usage of bot",
 	"commit":    "Displays random commit message from github",
 	"offensive": "Displays a potentially offensive statement.",
@@ -39,15 +39,26 @@ var helpStrings = map[string]string{
 	"excuse":    "Fetches an excuse from http://programmingexcuses.com/",
 	"join":      "Joins channel(s) supplied as argument(s). Admin only command",
 	"part":      "Parts channel(s) supplied as argument(s). Admin only command",
+	"ignore":    "Ignores messages from hostmask(s) (nick!user@host, * and ? wildcards) supplied as argument(s). Admin only command",
+	"unignore":  "Stops ignoring hostmask(s) supplied as argument(s). Admin only command",
+	"ignores":   "Lists ignored hostmasks. Admin only command",
+	"grep":      "Displays the most recent messages in channel containing all of the given words",
+	"merge":     "Counts nicks supplied as arguments as the same person in wc and top. With one nick, merges it with yours. Only nicks last used from your user@host unless admin",
+	"unmerge":   "Stops counting nick supplied as argument, or yours, as the same person as its other nicks",
+	"aliases":   "Lists the nicks counted as the same person as nick supplied as argument, or yours",
+	"seen":      "Displays when nick supplied as argument (* and ? wildcards allowed) last spoke, joined, parted or quit",
+	"noseen":    "Stops seen from tracking your nick. 'noseen off' lets it track you again",
+	"export":    "Privately sends a download link for a channel's log between two days (YYYY-MM-DD). Takes the channel, the first and last day, and optionally the format (json, txt or html). Admin only command",
 }
 
 //command is the format for all bot command functions. The chan string is used to send generated output to the server;
 //the first string is the channel from which the command is called and reply is sent to;
 //the second string is the nick that called the command;
-//the third string is the hostname of the user that called the command;
+//the third string is the username (ident) of the user that called the command;
+//the fourth string is the hostname of the user that called the command;
 //and the []string contains any arguments to the command.
 //All commands direct any output to both chan string (the IRC server) and console.
-type command func(chan string, string, string, string, []string)
+type command func(chan string, string, string, string, string, []string)
 
 //initMap is used to populate the global variable funcMap in irc.go,
 //it allows calling functions based upon strings.
@@ -74,59 +85,49 @@ func initMap() map[string]command {
 		"excuse":    command(excuse),
 		"join":      command(join),
 		"part":      command(part),
+		"ignore":    command(ignore),
+		"unignore":  command(unignore),
+		"ignores":   command(listIgnores),
+		"grep":      command(grep),
+		"export":    command(export),
+		"seen":      command(seen),
+		"noseen":    command(noseen),
+		"merge":     command(merge),
+		"unmerge":   command(unmerge),
+		"aliases":   command(aliases),
 	}
 }
 
-//initCmdRedis initializes the global variable cmdDb via the local database
-func initCmdRedis() error {
-	var err error
-	cmdDb, err = redis.Dial("tcp", "127.0.0.1:6379")
-	return err
-}
-
-//returns true if uname@hostname is verified with a webserver username
-func checkVerified(uname, hostname string) bool {
-	reply := cmdDb.Cmd("get", uname+"Host")
-	hostnameDb, err := reply.Bytes()
-	if err != nil {
-		log.Println(err.Error())
-	}
-	if hostname == string(hostnameDb) {
-		return true
-	}
-	return false
-}
-
 //source outputs a link to the repository on github
-func source(srvChan chan string, channel, nick, hostname string, args []string) {
-	message := "NOTICE " + channel + " :https://github.com/heydabop/yaircb"
+func source(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel) + "https://github.com/heydabop/yaircb"
 	log.Println(message)
 	srvChan <- message
 }
 
 //botsnack outputs a pointless message
-func botsnack(srvChan chan string, channel, nick, hostname string, args []string) {
-	message := "NOTICE " + channel + " :Kisses commend. Perplexities deprave."
+func botsnack(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel) + "Kisses commend. Perplexities deprave."
 	log.Println(message)
 	srvChan <- message
 }
 
 //register outputs a link to register with the webserver
-func register(srvChan chan string, channel, nick, hostname string, args []string) {
-	message := "NOTICE " + channel + " :https://anex.us/register/"
+func register(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel) + webURL("/register/")
 	log.Println(message)
 	srvChan <- message
 }
 
 //uptime outputs the command 'uptime'
-func uptime(srvChan chan string, channel, nick, hostname string, args []string) {
+func uptime(srvChan chan string, channel, nick, user, hostname string, args []string) {
 	out, err := exec.Command("uptime").Output()
 	if err != nil {
 		log.Println(err)
 		return
 	}
 	outFields := strings.Split(strings.TrimSpace(string(out)), ",")
-	message := "NOTICE " + channel + " :System: " + strings.Join(outFields[:2], ",")
+	message := replyPrefix(channel) + "System: " + strings.Join(outFields[:2], ",")
 	selfUptime := time.Since(startTime)
 	message += fmt.Sprintf(" || Self: %d days, %02d:%02d", int(selfUptime.Hours())/24, int(selfUptime.Hours())%24, int(selfUptime.Minutes())%60)
 	log.Println(message)
@@ -134,42 +135,88 @@ func uptime(srvChan chan string, channel, nick, hostname string, args []string)
 }
 
 //web outputs a link to the homepage of the webserver
-func web(srvChan chan string, channel, nick, hostname string, args []string) {
-	message := "NOTICE " + channel + " :https://anex.us/"
+func web(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel) + webURL("/")
 	log.Println(message)
 	srvChan <- message
 }
 
 //login outputs a link to the login page of the webserver
-func login(srvChan chan string, channel, nick, hostname string, args []string) {
-	message := "NOTICE " + channel + " :https://anex.us/login/"
+func login(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel) + webURL("/login/")
 	log.Println(message)
 	srvChan <- message
 }
 
 //verify takes two arguments, the first being a username, the second being a PIN associated to that username.
 //verify <username> <pin>
-//If the username and PIN match those displayed on a user page on the webserver, then the IRC nick@hostname and webserver
-//username become associated to each other.
-func verify(srvChan chan string, channel, nick, hostname string, args []string) {
-	message := "NOTICE " + channel + " :"
+//If the username and PIN match those displayed on a user page on the webserver, then the IRC nick!user@hostname is
+//added to the identities linked to the webserver username. PINs can only be used once, expire, and stop being checked
+//for a while after too many wrong guesses.
+func verify(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel)
 	if len(args) != 2 {
-		message = "NOTICE " + channel + " :ERROR: Invalid number of arguments"
+		message = replyPrefix(channel) + tr(channel, "ERROR: Invalid number of arguments")
 	} else {
 		uname := args[0]
 		pin := args[1]
-		reply := cmdDb.Cmd("get", uname+"Pin")
-		pinDb, err := (reply.Bytes())
+		locked, err := pinLocked(uname)
+		if err != nil {
+			log.Println(err.Error())
+			return
+		}
+		pinDb, _, err := userPin(uname)
 		if err != nil {
 			log.Println(err.Error())
 			return
 		}
-		if string(pinDb) == pin {
-			message += "You are now verified as " + uname
-			cmdDb.Cmd("set", uname+"Host", hostname)
-			cmdDb.Cmd("set", uname+"Pin", fmt.Sprintf("%06d", rand.Intn(1000000)))
+		if locked {
+			message += "Too many wrong PINs for " + uname + ", try again later"
+		} else if pinDb != "" && subtle.ConstantTimeCompare([]byte(pinDb), []byte(pin)) == 1 {
+			identities, err := userIdentities(uname)
+			if err != nil {
+				log.Println(err.Error())
+				return
+			}
+			id := identity{network(), nick, user, hostname, ircAccount(nick), time.Now(), false}
+			count := 1   //identities uname will have, this one replacing itself if it's verified again
+			linkTo := "" //nick of uname's most recently verified other identity on this network
+			for _, known := range identities {
+				if known.ID() == id.ID() {
+					id.Linked = known.Linked
+					continue
+				}
+				count++
+				if linkTo == "" && known.Network == network() {
+					linkTo = known.Nick
+				}
+			}
+			if linkTo != "" { //nicks verified as the same user are the same person
+				if linked, err := linkNicks(linkTo, nick); err != nil {
+					log.Println(err.Error())
+				} else if linked {
+					id.Linked = true
+				}
+			}
+			if err := addIdentity(uname, id); err != nil {
+				log.Println(err.Error())
+				return
+			}
+			if err := clearPinFailures(uname); err != nil {
+				log.Println(err.Error())
+			}
+			if _, _, err := newUserPin(uname); err != nil { //PINs are single use
+				log.Println(err.Error())
+			}
+			message += fmt.Sprintf("You are now verified as %s at %s; identities verified for %s: %d", uname, hostname,
+				uname, count)
 		} else {
-			message += "PIN does not match that of " + uname
+			if pinDb != "" {
+				if err := recordPinFailure(uname); err != nil {
+					log.Println(err.Error())
+				}
+			}
+			message += "PIN does not match that of " + uname + ", or it has expired"
 		}
 	}
 	log.Println(message)
@@ -178,15 +225,22 @@ func verify(srvChan chan string, channel, nick, hostname string, args []string)
 
 //verified takes one argument, the username against which the IRC user is testing association
 //verified <username>
-//If the IRC nick@hostname is associated to the webserver username, that state is indicated by the bot's response.
-func verified(srvChan chan string, channel, nick, hostname string, args []string) {
-	message := "NOTICE " + channel + " :"
+//If the IRC hostname matches one of the identities linked to the webserver username, that state is indicated by the
+//bot's response.
+func verified(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel)
 	if len(args) != 1 {
-		message += ":ERROR: Invalid number of arguments"
+		message += tr(channel, "ERROR: Invalid number of arguments")
 	} else {
 		uname := args[0]
-		if checkVerified(uname, hostname) {
-			message += "You are " + uname + " at " + hostname
+		identities, err := userIdentities(uname)
+		if err != nil {
+			log.Println(err.Error())
+			return
+		}
+		if _, found := matchIdentity(identities, hostname); found {
+			message += fmt.Sprintf("You are %s at %s; identities verified for %s: %d", uname, hostname, uname,
+				len(identities))
 		} else {
 			message += "You are not " + uname
 		}
@@ -198,12 +252,12 @@ func verified(srvChan chan string, channel, nick, hostname string, args []string
 //help takes one argument, the command for which help is being requested
 //help <command>
 //returns string from helpStrings overviewing command
-func help(srvChan chan string, channel, nick, hostname string, args []string) {
-	message := "NOTICE " + channel + " :"
+func help(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel)
 	if len(args) == 0 {
-		message += "Try help <command>. For a list of commands try 'yaircb: commands'"
+		message += tr(channel, "Try help <command>. For a list of commands try 'yaircb: commands'")
 	} else if len(args) != 1 {
-		message += "ERROR: Invalid number of arguments"
+		message += tr(channel, "ERROR: Invalid number of arguments")
 	} else {
 		if docString, found := helpStrings[args[0]]; found {
 			message += args[0] + ": " + docString
@@ -216,10 +270,12 @@ func help(srvChan chan string, channel, nick, hostname string, args []string) {
 }
 
 //commands outputs every publicly callable command
-func commands(srvChan chan string, channel, nick, hostname string, args []string) {
-	message := "NOTICE " + channel + " :"
+func commands(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel)
 	for command := range funcMap {
-		message += command + " "
+		if commandEnabled(channel, command) {
+			message += command + " "
+		}
 	}
 	log.Println(message)
 	srvChan <- message
@@ -228,14 +284,14 @@ func commands(srvChan chan string, channel, nick, hostname string, args []string
 //kick takes at least one and up to two arguments
 //kick <nick> <reason>
 //If the bot has OP, nick is kicked with reason. The caller of the command is held responsible...
-func kick(srvChan chan string, channel, nick, hostname string, args []string) {
+func kick(srvChan chan string, channel, nick, user, hostname string, args []string) {
 	message := "KICK " + channel + " " + nick + " :You don't tell me what to do."
 	log.Println(message)
 	srvChan <- message
 
 	message = "KICK " + channel
 	if len(args) < 1 {
-		message = "NOTICE " + channel + " :ERROR: Invalid number of arguments"
+		message = replyPrefix(channel) + tr(channel, "ERROR: Invalid number of arguments")
 	} else {
 		if args[0] == config.Nick {
 			return
@@ -249,50 +305,41 @@ func kick(srvChan chan string, channel, nick, hostname string, args []string) {
 	srvChan <- message
 }
 
-//wc takes one argument, the user who's messages are being counted
-//wc <nick>
+//wc takes one or two arguments, the user who's messages are being counted and optionally a time window
+//wc <nick> [today|yesterday|week|month|all]
 //wc outputs the number of messages nick has said in channel
-func wc(srvChan chan string, channel, nick, hostname string, args []string) {
-	message := "NOTICE " + channel + " :"
-	if len(args) != 1 {
-		message += "ERROR: Invalid number of arguments"
+func wc(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel)
+	if len(args) < 1 || len(args) > 2 {
+		message += tr(channel, "ERROR: Invalid number of arguments")
 	} else {
-		logFile, err := os.Open(`/home/ross/irclogs/freenode/` + channel + `.log`)
-		if err != nil {
-			log.Println(err.Error())
+		window := "all"
+		if len(args) == 2 {
+			window = args[1]
 		}
-		fileStat, err := logFile.Stat()
+		matches, err := nickCount(channel, args[0], window)
 		if err != nil {
 			log.Println(err.Error())
+			message += "ERROR: " + err.Error()
+			srvChan <- message
 			return
 		}
-		logBytes := make([]byte, fileStat.Size())
-		_, err = logFile.Read(logBytes)
-		if err != nil {
-			log.Println(err.Error())
-			return
-		}
-		logLines := strings.Split(string(logBytes), "\n")
-		nickLine := regexp.MustCompile(`^\d\d:\d\d <[@\+\s]?` + args[0] + `>`)
-		matches := 0
-		for _, line := range logLines {
-			if match := nickLine.FindStringSubmatch(line); match != nil {
-				matches++
-			}
-		}
 		message += args[0] + ": " + fmt.Sprintf("%d", matches) + " lines"
+		if nicks := personNicks(args[0]); len(nicks) > 1 {
+			message += " (as " + strings.Join(nicks, ", ") + ")"
+		}
 	}
 	log.Println(message)
 	srvChan <- message
 }
 
-//top takes one argument, the number of nick line counts to output
-//top <n>
+//top takes one or two arguments, the number of nick line counts to output and optionally a time window
+//top <n> [today|yesterday|week|month|all]
 //top outputs the most active n users, by outputting their nicks and the number of messages in channel
-func top(srvChan chan string, channel, nick, hostname string, args []string) {
-	message := "NOTICE " + channel + " :"
-	if len(args) != 1 {
-		message += "ERROR: Invalid number of arguments"
+func top(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel)
+	if len(args) < 1 || len(args) > 2 {
+		message += tr(channel, "ERROR: Invalid number of arguments")
 	} else {
 		nicks64, err := strconv.ParseInt(args[0], 10, 0)
 		if err != nil {
@@ -300,35 +347,22 @@ func top(srvChan chan string, channel, nick, hostname string, args []string) {
 			return
 		}
 		if nicks64 < 1 {
-			message += "ERROR: Must supply a positive integer"
+			message += tr(channel, "ERROR: Must supply a positive integer")
 		} else {
 			nicks := int(nicks64)
-			logFile, err := os.Open(`/home/ross/irclogs/freenode/` + channel + `.log`)
-			if err != nil {
-				log.Println(err.Error())
-				return
+			window := "all"
+			if len(args) == 2 {
+				window = args[1]
 			}
-			fileStat, err := logFile.Stat()
+			matches, err := channelCounts(channel, window)
 			if err != nil {
 				log.Println(err.Error())
+				message += "ERROR: " + err.Error()
+				srvChan <- message
 				return
 			}
-			logBytes := make([]byte, fileStat.Size())
-			_, err = logFile.Read(logBytes)
-			if err != nil {
-				log.Println(err.Error())
-				return
-			}
-			logLines := strings.Split(string(logBytes), "\n")
-			nickLine := regexp.MustCompile(`^\d\d:\d\d <[@\+\s]?(\S*?)>`)
-			matches := make(map[string]uint)
-			for _, line := range logLines {
-				if match := nickLine.FindStringSubmatch(line); match != nil {
-					matches[strings.ToLower(match[1])]++
-				}
-			}
 			for i := 0; i < nicks; i++ {
-				maxLines := uint(0)
+				maxLines := 0
 				var maxNick string
 				for nick, lines := range matches {
 					if lines > maxLines {
@@ -351,21 +385,21 @@ func top(srvChan chan string, channel, nick, hostname string, args []string) {
 //yesNo is not called like other commands, and is instead instantiated when a message starts with the bot name and ends
 //with a question mark.
 //yesNo randomly outputs "Yes." or "No."
-func yesNo(srvChan chan string, channel, nick, hostname string) {
-	message := "NOTICE " + channel + " :"
+func yesNo(srvChan chan string, channel, nick, user, hostname string) {
+	message := replyPrefix(channel)
 	x := rand.Intn(2)
 	if x == 1 {
-		message += "Yes."
+		message += tr(channel, "Yes.")
 	} else {
-		message += "No."
+		message += tr(channel, "No.")
 	}
 	log.Println(message)
 	srvChan <- message
 }
 
 //footprint outputs the resident memory usage of the process
-func footprint(srvChan chan string, channel, nick, hostname string, args []string) {
-	message := "NOTICE " + channel + " :"
+func footprint(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel)
 	pid := os.Getpid()
 	out, err := exec.Command("grep", "VmRSS", "/proc/"+fmt.Sprintf("%d", pid)+"/status").Output()
 	if err != nil {
@@ -382,7 +416,7 @@ func footprint(srvChan chan string, channel, nick, hostname string, args []strin
 
 //commit randomly selects a github repository and commit and outputs the first line of the commit
 //and a goo.gl URL of the commit
-func commit(srvChan chan string, channel, nick, hostname string, args []string) {
+func commit(srvChan chan string, channel, nick, user, hostname string, args []string) {
 	type repoJSON struct {
 		Id          int
 		Owner       map[string]interface{}
@@ -409,7 +443,7 @@ func commit(srvChan chan string, channel, nick, hostname string, args []string)
 		Id      string
 		LongUrl string
 	}
-	message := "NOTICE " + channel + " :"
+	message := replyPrefix(channel)
 	since := rand.Intn(1000000)
 	res, err := http.Get("https://api.github.com/repositories?since=" + fmt.Sprintf("%d", since))
 	if err != nil {
@@ -437,7 +471,7 @@ func commit(srvChan chan string, channel, nick, hostname string, args []string)
 	var commits []commitJSON
 	json.Unmarshal(body, &commits)
 	if len(commits) < 1 {
-		commit(srvChan, channel, nick, hostname, args) //try again
+		commit(srvChan, channel, nick, user, hostname, args) //try again
 		return
 	} else {
 		commitNum := rand.Intn(len(commits))
@@ -469,8 +503,8 @@ func commit(srvChan chan string, channel, nick, hostname string, args []string)
 }
 
 //offensive displays a potentially offensive statement
-func offensive(srvChan chan string, channel, nick, hostname string, args []string) {
-	message := "NOTICE " + channel + " :"
+func offensive(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel)
 	out, err := exec.Command("fortune", "-os").Output()
 	if err != nil {
 		log.Println(err.Error())
@@ -483,26 +517,26 @@ func offensive(srvChan chan string, channel, nick, hostname string, args []strin
 }
 
 //dice displays a number in the range [1, 6]
-func dice(srvChan chan string, channel, nick, hostname string, args []string) {
-	message := "NOTICE " + channel + " :" + fmt.Sprintf("%d", rand.Intn(6)+1)
+func dice(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel) + fmt.Sprintf("%d", rand.Intn(6)+1)
 	log.Println(message)
 	srvChan <- message
 }
 
 //coin displays either heads or tails
-func coin(srvChan chan string, channel, nick, hostname string, args []string) {
-	message := "NOTICE " + channel + " :"
+func coin(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel)
 	if rand.Intn(2) == 0 {
-		message += "Heads."
+		message += tr(channel, "Heads.")
 	} else {
-		message += "Tails."
+		message += tr(channel, "Tails.")
 	}
 	log.Println(message)
 	srvChan <- message
 }
 
 //ctcp is not called like other commands, and is instead used to reply to CTCP requests
-func ctcp(srvChan chan string, channel, nick, hostname string, args []string) {
+func ctcp(srvChan chan string, channel, nick, user, hostname string, args []string) {
 	message := "NOTICE " + nick + " :\x01"
 	ctcpType := args[0]
 	switch ctcpType {
@@ -551,8 +585,8 @@ func ctcp(srvChan chan string, channel, nick, hostname string, args []string) {
 }
 
 //excuse fetches an excuse from http://programmingexcuses.com/
-func excuse(srvChan chan string, channel, nick, hostname string, args []string) {
-	message := "NOTICE " + channel + " :"
+func excuse(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel)
 	res, err := http.Get("http://programmingexcuses.com/")
 	if err != nil {
 		log.Println(err.Error())
@@ -576,19 +610,16 @@ func excuse(srvChan chan string, channel, nick, hostname string, args []string)
 }
 
 //join joins channel(s) supplied as argument(s). Admin only command
-func join(srvChan chan string, channel, nick, hostname string, args []string) {
-	message := "NOTICE " + channel + " :"
+func join(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel)
 	if len(args) < 1 {
-		message += "ERROR: Not enough arguments."
+		message += tr(channel, "ERROR: Not enough arguments.")
 	} else if checkVerified(nick, hostname) {
-		for _, admin := range config.Admins {
-			adminNickHost := strings.Split(admin, "@")
-			if nick == adminNickHost[0] && hostname == adminNickHost[1] {
-				joinMessage := "JOIN " + strings.Join(args, ",")
-				srvChan <- joinMessage
-				log.Println(joinMessage)
-				return
-			}
+		if isAdmin(nick, user, hostname) {
+			joinMessage := "JOIN " + strings.Join(args, ",")
+			srvChan <- joinMessage
+			log.Println(joinMessage)
+			return
 		}
 		message += nick + " IS UNAUTHORIZED."
 	} else {
@@ -599,24 +630,287 @@ func join(srvChan chan string, channel, nick, hostname string, args []string) {
 }
 
 //part parts channel(s) supplied as argument(s). Admin only command
-func part(srvChan chan string, channel, nick, hostname string, args []string) {
-	message := "NOTICE " + channel + " :"
+func part(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel)
 	if len(args) < 1 {
-		message += "ERROR: Not enough arguments."
+		message += tr(channel, "ERROR: Not enough arguments.")
 	} else if checkVerified(nick, hostname) {
-		for _, admin := range config.Admins {
-			adminNickHost := strings.Split(admin, "@")
-			if nick == adminNickHost[0] && hostname == adminNickHost[1] {
-				partMessage := "PART " + strings.Join(args, ",")
-				srvChan <- partMessage
-				log.Println(partMessage)
+		if isAdmin(nick, user, hostname) {
+			partMessage := "PART " + strings.Join(args, ",")
+			srvChan <- partMessage
+			log.Println(partMessage)
+			return
+		}
+		message += nick + " IS UNAUTHORIZED."
+	} else {
+		message += "I don't know who " + nick + " is. Please verify yourself."
+	}
+	srvChan <- message
+	log.Println(message)
+}
+
+//ignore adds hostmask(s) supplied as argument(s) to the ignore list. Admin only command
+func ignore(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel)
+	if len(args) < 1 {
+		message += tr(channel, "ERROR: Not enough arguments.")
+	} else if checkVerified(nick, hostname) {
+		if isAdmin(nick, user, hostname) {
+			for _, mask := range args {
+				if addIgnore(mask) {
+					message += "Ignoring " + mask + ". "
+				} else {
+					message += mask + " is already ignored. "
+				}
+			}
+		} else {
+			message += nick + " IS UNAUTHORIZED."
+		}
+	} else {
+		message += "I don't know who " + nick + " is. Please verify yourself."
+	}
+	srvChan <- message
+	log.Println(message)
+}
+
+//unignore removes hostmask(s) supplied as argument(s) from the ignore list. Admin only command
+func unignore(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel)
+	if len(args) < 1 {
+		message += tr(channel, "ERROR: Not enough arguments.")
+	} else if checkVerified(nick, hostname) {
+		if isAdmin(nick, user, hostname) {
+			for _, mask := range args {
+				if removeIgnore(mask) {
+					message += "No longer ignoring " + mask + ". "
+				} else {
+					message += mask + " isn't ignored. "
+				}
+			}
+		} else {
+			message += nick + " IS UNAUTHORIZED."
+		}
+	} else {
+		message += "I don't know who " + nick + " is. Please verify yourself."
+	}
+	srvChan <- message
+	log.Println(message)
+}
+
+//listIgnores outputs the ignore list. Admin only command
+func listIgnores(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel)
+	if checkVerified(nick, hostname) {
+		if !isAdmin(nick, user, hostname) {
+			message += nick + " IS UNAUTHORIZED."
+		} else if masks := ignores(); len(masks) > 0 {
+			message += "Ignoring: " + strings.Join(masks, " ")
+		} else {
+			message += "Not ignoring anyone."
+		}
+	} else {
+		message += "I don't know who " + nick + " is. Please verify yourself."
+	}
+	srvChan <- message
+	log.Println(message)
+}
+
+//grep takes at least one argument, the words to search for
+//grep <word> [word...]
+//grep outputs the three most recent messages in channel containing every word, along with when and by whom they were said
+func grep(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel)
+	if len(args) < 1 {
+		message += tr(channel, "ERROR: Not enough arguments.")
+	} else {
+		results, more, err := searchLog(channel, strings.Join(args, " "), 0, 3)
+		if err != nil {
+			log.Println(err.Error())
+			message += "ERROR: " + err.Error()
+		} else if len(results) == 0 {
+			message += "No matches."
+		} else {
+			formatted := make([]string, len(results))
+			for i, result := range results {
+				formatted[i] = formatResult(result)
+			}
+			message += strings.Join(formatted, " || ")
+			if more {
+				message += " || More at " + webURL("/logs/search/?channel="+url.QueryEscape(channel)+"&q="+
+					url.QueryEscape(strings.Join(args, " ")))
+			}
+		}
+	}
+	log.Println(message)
+	srvChan <- message
+}
+
+//export takes three or four arguments, the channel, the first and last day of the export and optionally its format
+//export <channel> <YYYY-MM-DD> <YYYY-MM-DD> [json|txt|html]
+//export sends the admin a link to download the log, valid for an hour, in a private notice. Admin only command
+func export(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel)
+	if len(args) < 3 {
+		message += tr(channel, "ERROR: Not enough arguments.")
+	} else if checkVerified(nick, hostname) {
+		if isAdmin(nick, user, hostname) {
+			req := exportRequest{Channel: args[0], From: args[1], To: args[2]}
+			if len(args) > 3 {
+				req.Format = strings.ToLower(args[3])
+			}
+			if err := req.validate(); err != nil {
+				message += "ERROR: " + err.Error()
+			} else if link, err := newExportLink(req); err != nil {
+				log.Println(err.Error())
+				message += "ERROR: " + err.Error()
+			} else { //anyone with the link can read the logs, so only the admin who asked is told it, and it isn't logged
+				message = "NOTICE " + nick + " :Download " + req.Channel + " from " + req.From + " to " + req.To +
+					" at " + link + " (valid for an hour)"
+				srvChan <- message
+				log.Println(strings.Replace(message, link, webURL("/export/?token=(hidden)"), 1))
 				return
 			}
+		} else {
+			message += nick + " IS UNAUTHORIZED."
 		}
-		message += nick + " IS UNAUTHORIZED."
 	} else {
 		message += "I don't know who " + nick + " is. Please verify yourself."
 	}
 	srvChan <- message
 	log.Println(message)
 }
+
+//mayMerge reports whether nick!user@hostname may change which person target belongs to: their own nick, nicks they
+//last spoke as from the same user@host, and any nick for admins
+func mayMerge(nick, user, hostname, target string) bool {
+	return ircEqual(nick, target) || sameUser(target, user, hostname) ||
+		(checkVerified(nick, hostname) && isAdmin(nick, user, hostname))
+}
+
+//merge takes one or more arguments, the nicks to count as the same person
+//merge <nick> [nick...]
+//merge links every nick with the first, or the only nick with the caller's nick
+func merge(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel)
+	if len(args) < 1 {
+		message += tr(channel, "ERROR: Not enough arguments.")
+	} else {
+		if len(args) == 1 {
+			args = []string{nick, args[0]}
+		}
+		for _, target := range args {
+			if !mayMerge(nick, user, hostname, target) {
+				message += nick + " IS UNAUTHORIZED to merge " + target + "."
+				srvChan <- message
+				log.Println(message)
+				return
+			}
+		}
+		for _, target := range args[1:] {
+			linked, err := linkNicks(args[0], target)
+			if err != nil {
+				log.Println(err.Error())
+				message += "ERROR: " + err.Error()
+				break
+			}
+			if linked {
+				message += "Merged " + target + " with " + args[0] + ". "
+			} else {
+				message += target + " is already merged with " + args[0] + ". "
+			}
+		}
+	}
+	srvChan <- message
+	log.Println(message)
+}
+
+//unmerge takes up to one argument, the nick to stop counting as the same person as its other nicks
+//unmerge [nick]
+func unmerge(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel)
+	target := nick
+	if len(args) > 0 {
+		target = args[0]
+	}
+	if len(args) > 1 {
+		message += tr(channel, "ERROR: Invalid number of arguments")
+	} else if !mayMerge(nick, user, hostname, target) {
+		message += nick + " IS UNAUTHORIZED to unmerge " + target + "."
+	} else if unlinked, err := unlinkNick(target); err != nil {
+		log.Println(err.Error())
+		message += "ERROR: " + err.Error()
+	} else if unlinked {
+		message += target + " is no longer merged with other nicks."
+	} else {
+		message += target + " isn't merged with other nicks."
+	}
+	srvChan <- message
+	log.Println(message)
+}
+
+//aliases takes up to one argument, the nick whose aliases are listed
+//aliases [nick]
+//aliases outputs every nick counted as the same person as nick, or as the caller
+func aliases(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel)
+	target := nick
+	if len(args) > 0 {
+		target = args[0]
+	}
+	if len(args) > 1 {
+		message += tr(channel, "ERROR: Invalid number of arguments")
+	} else if nicks := personNicks(target); len(nicks) > 1 {
+		message += target + " is also known as: " + strings.Join(nicks, " ")
+	} else {
+		message += target + " has no aliases."
+	}
+	srvChan <- message
+	log.Println(message)
+}
+
+//seen takes one argument, the nick or wildcard pattern to look up
+//seen <nick>
+//seen outputs what nick, or the up to three most recently active nicks matching the pattern, were last seen doing
+func seen(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel)
+	if len(args) != 1 {
+		message += tr(channel, "ERROR: Invalid number of arguments")
+	} else if records, err := lastSeen(args[0]); err != nil {
+		log.Println(err.Error())
+		message += "ERROR: " + err.Error()
+	} else if len(records) == 0 {
+		message += "I haven't seen " + args[0] + "."
+	} else {
+		if len(records) > 3 {
+			message += fmt.Sprintf("%d nicks match, most recent first: ", len(records))
+			records = records[:3]
+		}
+		described := make([]string, len(records))
+		for i, record := range records {
+			described[i] = describeSeen(record, channel)
+		}
+		message += strings.Join(described, " || ")
+	}
+	srvChan <- message
+	log.Println(message)
+}
+
+//noseen takes up to one argument, "off" to opt back in to being tracked
+//noseen [off]
+//noseen stops seen from tracking the caller's nick and forgets what it was last seen doing
+func noseen(srvChan chan string, channel, nick, user, hostname string, args []string) {
+	message := replyPrefix(channel)
+	optOut := len(args) == 0 || !strings.EqualFold(args[0], "off")
+	if len(args) > 1 {
+		message += tr(channel, "ERROR: Invalid number of arguments")
+	} else if err := setSeenOptOut(nick, optOut); err != nil {
+		log.Println(err.Error())
+		message += "ERROR: " + err.Error()
+	} else if optOut {
+		message += "I'll no longer remember when I last saw " + nick + "."
+	} else {
+		message += "I'll remember when I last saw " + nick + " again."
+	}
+	srvChan <- message
+	log.Println(message)
+}
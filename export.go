@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//exportRequest describes a log export, stored behind the token of a download link
+type exportRequest struct {
+	Channel string
+	From    string //first day, 2006-01-02
+	To      string //last day, 2006-01-02
+	Format  string //json, txt or html
+}
+
+//exportTypes maps export formats to their content types
+var exportTypes = map[string]string{
+	"json": "application/x-ndjson; charset=utf-8",
+	"txt":  "text/plain; charset=utf-8",
+	"html": "text/html; charset=utf-8",
+}
+
+//exportPage is passed to export.html
+type exportPage struct {
+	Channel string
+	From    string
+	To      string
+	Style   template.CSS
+	Days    []exportDay
+}
+
+//exportDay is one day of an HTML export
+type exportDay struct {
+	Day   string
+	Lines []logLine
+}
+
+//validate checks that req describes a possible export, filling in the default format
+func (req *exportRequest) validate() error {
+	if !isChannel(req.Channel) {
+		return errors.New("Invalid channel '" + req.Channel + "'")
+	}
+	if req.Format == "" {
+		req.Format = "txt"
+	}
+	if _, found := exportTypes[req.Format]; !found {
+		return errors.New("Unknown format '" + req.Format + "', try json, txt or html")
+	}
+	from, err := time.Parse("2006-01-02", req.From)
+	if err != nil {
+		return errors.New("Invalid date '" + req.From + "', use YYYY-MM-DD")
+	}
+	to, err := time.Parse("2006-01-02", req.To)
+	if err != nil {
+		return errors.New("Invalid date '" + req.To + "', use YYYY-MM-DD")
+	}
+	if to.Before(from) {
+		return errors.New("The end of the range is before its start")
+	}
+	return nil
+}
+
+//exportDays returns the days of req's range that have logs, oldest first
+func exportDays(req exportRequest) ([]string, error) {
+	index, err := indexDays(logPath(req.Channel))
+	if err != nil {
+		return nil, err
+	}
+	var days []string
+	for _, day := range index.days {
+		if day >= req.From && day <= req.To { //dates formatted 2006-01-02 sort as strings
+			days = append(days, day)
+		}
+	}
+	return days, nil
+}
+
+//exportLog writes the part of a channel's log described by req to w
+func exportLog(w io.Writer, req exportRequest) error {
+	days, err := exportDays(req)
+	if err != nil {
+		return err
+	}
+	page := exportPage{Channel: req.Channel, From: req.From, To: req.To}
+	if req.Format == "html" {
+		style, err := fs.ReadFile(webFiles(), "resources/logStyle.css")
+		if err == nil {
+			page.Style = template.CSS(style)
+		}
+	}
+	encoder := json.NewEncoder(w)
+	for _, day := range days {
+		events, err := readDay(logPath(req.Channel), day)
+		if err != nil {
+			return err
+		}
+		switch req.Format {
+		case "json":
+			for _, event := range events {
+				if err := encoder.Encode(event); err != nil {
+					return err
+				}
+			}
+		case "txt":
+			if len(events) > 0 {
+				fmt.Fprintf(w, "--- Day changed %s\n", events[0].Time.Format("Mon Jan 02 2006"))
+			}
+			for _, event := range events {
+				if _, err := fmt.Fprintln(w, formatIrssi(req.Channel, event)); err != nil {
+					return err
+				}
+			}
+		case "html":
+			exported := exportDay{Day: day}
+			for i, event := range events {
+				exported.Lines = append(exported.Lines, logLine{
+					Anchor: fmt.Sprintf("%s-L%d", day, i+1),
+					Time:   event.Time.Format("15:04"),
+					Nick:   event.Nick,
+					Colour: nickColour(event.Nick),
+					Type:   event.Type,
+					HTML:   describeEvent(event),
+				})
+			}
+			page.Days = append(page.Days, exported)
+		}
+	}
+	if req.Format == "html" {
+		t, err := template.ParseFS(webFiles(), "export.html")
+		if err != nil {
+			return err
+		}
+		return t.Execute(w, page)
+	}
+	return nil
+}
+
+//newExportLink stores req behind a random token that expires after an hour and returns the URL to download it from
+func newExportLink(req exportRequest) (string, error) {
+	token, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+	reqBytes, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	if err := db.Set(keyPrefix+"export:"+token, string(reqBytes), time.Hour); err != nil {
+		return "", err
+	}
+	return webURL("/export/?token=" + token), nil
+}
+
+//exportHandler serves /export/, either ?token=<token> from a link given out by the export command, or
+///export/?channel=<channel>&from=<day>&to=<day>&format=<format> for logged in users who may view the channel
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	var req exportRequest
+	if token := r.FormValue("token"); token != "" {
+		reqJSON, found, err := db.Get(keyPrefix + "export:" + token)
+		if err != nil || !found || json.Unmarshal([]byte(reqJSON), &req) != nil {
+			http.Error(w, "This download link is invalid or has expired", http.StatusNotFound)
+			return
+		}
+	} else {
+		if webUser(r) == "" {
+			http.Redirect(w, r, "/login/", http.StatusFound)
+			return
+		}
+		req = exportRequest{r.FormValue("channel"), r.FormValue("from"), r.FormValue("to"), r.FormValue("format")}
+		if err := req.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !canView(r, req.Channel) {
+			http.Error(w, "This channel's logs are private", http.StatusForbidden)
+			return
+		}
+	}
+	filename := strings.TrimLeft(safeName(req.Channel), "#&") + "-" + req.From + "-" + req.To + "." + req.Format
+	w.Header().Set("Content-Type", exportTypes[req.Format])
+	w.Header().Set("Content-Disposition", `attachment; filename="`+url.PathEscape(filename)+`"`)
+	if err := exportLog(w, req); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
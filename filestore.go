@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//fileStore is a memoryStore that journals every change to a file, so the bot can keep its data without a database
+//server. The journal is replayed when the store is opened and then rewritten with just the current data, which is
+//repeated as it grows.
+type fileStore struct {
+	*memoryStore
+	path      string
+	file      *os.File
+	writer    *bufio.Writer
+	stop      chan bool //stops the loop flushing the journal
+	ops       int       //changes written to the journal since it was last compacted
+	live      int       //changes written by the last compaction, a measure of how much data there is
+	compacted time.Time //when the journal was last compacted
+}
+
+//minCompactOps is the fewest changes journaled before the journal is compacted while the store is open. Past that it's
+//compacted whenever it's grown by as many changes as there were in it after the last compaction.
+const minCompactOps = 10000
+
+//compactInterval is how often the journal is compacted anyway if anything has changed, to drop keys that have expired
+const compactInterval = time.Hour
+
+//openFileStore opens the store journaled at path, creating it if it doesn't exist
+func openFileStore(path string) (*fileStore, error) {
+	store := &fileStore{memoryStore: newMemoryStore(), path: path, stop: make(chan bool)}
+	if err := store.replay(); err != nil {
+		return nil, err
+	}
+	if err := store.compact(); err != nil {
+		return nil, err
+	}
+	store.journal = store.write
+	go store.flushLoop()
+	return store, nil
+}
+
+//replay applies every change in the journal
+func (store *fileStore) replay() error {
+	file, err := os.Open(store.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var op storeOp
+		if err := json.Unmarshal(scanner.Bytes(), &op); err != nil {
+			continue //the last line may be incomplete if the bot was killed while writing it
+		}
+		store.apply(op)
+	}
+	return scanner.Err()
+}
+
+//compact removes expired keys and rewrites the journal with one change per key (or per field or member) holding the
+//current data, and opens it for appending
+func (store *fileStore) compact() error {
+	store.sweep()
+	if err := os.MkdirAll(filepath.Dir(store.path), 0755); err != nil {
+		return err
+	}
+	tmpFile, err := os.OpenFile(store.path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmpFile)
+	encoder := json.NewEncoder(writer)
+	var ops []storeOp
+	for key, value := range store.strings {
+		ops = append(ops, storeOp{Op: "set", Key: key, Values: []string{value}})
+	}
+	for key, hash := range store.hashes {
+		for field, value := range hash {
+			ops = append(ops, storeOp{Op: "hset", Key: key, Field: field, Values: []string{value}})
+		}
+	}
+	for key, set := range store.sets {
+		members := make([]string, 0, len(set))
+		for member := range set {
+			members = append(members, member)
+		}
+		ops = append(ops, storeOp{Op: "sadd", Key: key, Values: members})
+	}
+	for key, zset := range store.zsets {
+		for member, score := range zset {
+			ops = append(ops, storeOp{Op: "zadd", Key: key, Score: score, Values: []string{member}})
+		}
+	}
+	for key, expires := range store.expires {
+		ops = append(ops, storeOp{Op: "expire", Key: key, Expires: expires})
+	}
+	live := 0
+	for _, op := range ops {
+		if err := encoder.Encode(op); err != nil {
+			tmpFile.Close()
+			return err
+		}
+		live++
+	}
+	if err := writer.Flush(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(store.path+".tmp", store.path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(store.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	store.file, store.writer = file, bufio.NewWriter(file)
+	store.ops, store.live, store.compacted = 0, live, time.Now()
+	return nil
+}
+
+//write appends op to the journal. It's called by memoryStore.apply with the mutex held.
+func (store *fileStore) write(op storeOp) error {
+	opBytes, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	if _, err := store.writer.Write(append(opBytes, '\n')); err != nil {
+		return err
+	}
+	store.ops++
+	return nil
+}
+
+//flushLoop writes buffered changes to the file every second, so at most a second of changes is lost if the bot dies,
+//and compacts the journal once it's grown enough or compactInterval has passed
+func (store *fileStore) flushLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-store.stop:
+			return
+		case <-ticker.C:
+			store.mutex.Lock()
+			store.writer.Flush()
+			if (store.ops >= minCompactOps && store.ops >= store.live) ||
+				(store.ops > 0 && time.Since(store.compacted) >= compactInterval) {
+				store.recompact()
+			}
+			store.mutex.Unlock()
+		}
+	}
+}
+
+//recompact compacts the journal while the store is open, carrying on with the old journal if it can't. The caller
+//holds the mutex.
+func (store *fileStore) recompact() {
+	oldFile := store.file
+	if err := store.compact(); err != nil {
+		log.Println("Can't compact", store.path+":", err)
+		return
+	}
+	if err := oldFile.Close(); err != nil {
+		log.Println(err)
+	}
+}
+
+func (store *fileStore) Close() error {
+	close(store.stop)
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := store.writer.Flush(); err != nil {
+		store.file.Close()
+		return err
+	}
+	return store.file.Close()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net"
+	"strings"
+)
+
+//caseMapping is the casemapping advertised by the server in RPL_ISUPPORT (005); rfc1459 until told otherwise
+var caseMapping = "rfc1459"
+
+//ircLower lowercases s according to caseMapping. Under rfc1459 the characters []\~ are the uppercase forms of {}|^,
+//under strict-rfc1459 ~ and ^ are distinct, and under ascii only A-Z are folded.
+func ircLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			return r + ('a' - 'A')
+		case caseMapping == "ascii":
+			return r
+		case r == '[':
+			return '{'
+		case r == ']':
+			return '}'
+		case r == '\\':
+			return '|'
+		case r == '~' && caseMapping != "strict-rfc1459":
+			return '^'
+		}
+		return r
+	}, s)
+}
+
+//ircEqual reports whether a and b are the same nick or channel under caseMapping
+func ircEqual(a, b string) bool {
+	return ircLower(a) == ircLower(b)
+}
+
+//splitMask splits a hostmask into its nick, user and host parts. Missing parts are filled with *, so "nick" becomes
+//nick!*@*, "nick@host" becomes nick!*@host and "nick!user" becomes nick!user@*.
+func splitMask(mask string) (nick, user, host string) {
+	nick, user, host = mask, "*", "*"
+	if i := strings.LastIndex(nick, "@"); i >= 0 {
+		nick, host = nick[:i], nick[i+1:]
+	}
+	if i := strings.Index(nick, "!"); i >= 0 {
+		nick, user = nick[:i], nick[i+1:]
+	}
+	if nick == "" {
+		nick = "*"
+	}
+	if user == "" {
+		user = "*"
+	}
+	if host == "" {
+		host = "*"
+	}
+	return
+}
+
+//wildcardMatch reports whether s matches pattern, where * matches any run of characters (including none) and ? matches
+//exactly one character
+func wildcardMatch(pattern, s string) bool {
+	p, i := 0, 0
+	star, mark := -1, 0
+	for i < len(s) {
+		if p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]) {
+			p++
+			i++
+		} else if p < len(pattern) && pattern[p] == '*' {
+			star, mark = p, i
+			p++
+		} else if star >= 0 {
+			p = star + 1
+			mark++
+			i = mark
+		} else {
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+//hostMatch reports whether host matches the host part of a mask. A mask host in CIDR notation (e.g. 10.0.0.0/8)
+//matches any IP host in that network; anything else is compared as a case insensitive wildcard pattern.
+func hostMatch(maskHost, host string) bool {
+	if strings.Contains(maskHost, "/") {
+		if _, network, err := net.ParseCIDR(maskHost); err == nil {
+			ip := net.ParseIP(host)
+			return ip != nil && network.Contains(ip)
+		}
+	}
+	return wildcardMatch(strings.ToLower(maskHost), strings.ToLower(host))
+}
+
+//hostmaskMatch reports whether nick!user@host matches mask. Nicks are compared using the server casemapping.
+func hostmaskMatch(mask, nick, user, host string) bool {
+	maskNick, maskUser, maskHost := splitMask(mask)
+	return wildcardMatch(ircLower(maskNick), ircLower(nick)) &&
+		wildcardMatch(strings.ToLower(maskUser), strings.ToLower(user)) &&
+		hostMatch(maskHost, host)
+}
+
+//matchAny reports whether nick!user@host matches any mask in masks
+func matchAny(masks []string, nick, user, host string) bool {
+	for _, mask := range masks {
+		if hostmaskMatch(mask, nick, user, host) {
+			return true
+		}
+	}
+	return false
+}
+
+//isAdmin reports whether nick!user@host matches one of config.Admins
+func isAdmin(nick, user, hostname string) bool {
+	return matchAny(config.Admins, nick, user, hostname)
+}
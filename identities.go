@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+//identity is an IRC user verified as belonging to a web user
+type identity struct {
+	Network  string
+	Nick     string
+	User     string
+	Host     string
+	Account  string    //services account the nick was logged in to when it was verified, "" if unknown
+	Verified time.Time //zero for identities verified before the time was kept
+	Linked   bool      //whether verifying it linked Nick to the nick of another identity of the same user
+}
+
+//ID returns the field identity is kept under in its web user's identities hash, which is also how it's picked out to
+//be unlinked
+func (id identity) ID() string {
+	return id.Network + " " + ircLower(id.Nick+"!"+id.User+"@"+id.Host)
+}
+
+//accountTTL is how long the account a nick's message was tagged with is remembered. verify only needs the account of
+//the message it was sent in, so it doesn't have to be long.
+const accountTTL = 5 * time.Minute
+
+//taggedAccount is the services account a nick's message was tagged with, and when
+type taggedAccount struct {
+	account string
+	seen    time.Time
+}
+
+var (
+	accountMutex   sync.Mutex
+	ircAccounts    = make(map[string]taggedAccount) //lowercased nick -> account its last message was tagged with
+	accountsPruned time.Time                        //when accounts older than accountTTL were last forgotten
+)
+
+//noteAccount records the services account nick's last message was tagged with, "" if it wasn't logged in to one
+func noteAccount(nick, account string) {
+	now := time.Now()
+	accountMutex.Lock()
+	defer accountMutex.Unlock()
+	if now.Sub(accountsPruned) > time.Minute {
+		for other, tagged := range ircAccounts {
+			if now.Sub(tagged.seen) > accountTTL {
+				delete(ircAccounts, other)
+			}
+		}
+		accountsPruned = now
+	}
+	if account == "" || account == "*" {
+		delete(ircAccounts, ircLower(nick))
+	} else {
+		ircAccounts[ircLower(nick)] = taggedAccount{account, now}
+	}
+}
+
+//ircAccount returns the services account nick was logged in to when it last spoke, or "" if the server didn't say or
+//it hasn't spoken recently
+func ircAccount(nick string) string {
+	accountMutex.Lock()
+	defer accountMutex.Unlock()
+	tagged, found := ircAccounts[ircLower(nick)]
+	if !found || time.Since(tagged.seen) > accountTTL {
+		return ""
+	}
+	return tagged.account
+}
+
+//userIdentities returns the IRC identities verified as user name, most recently verified first
+func userIdentities(name string) ([]identity, error) {
+	fields, err := db.HGetAll(userKey(name, "identities"))
+	if err != nil {
+		return nil, err
+	}
+	identities := make([]identity, 0, len(fields))
+	for field, value := range fields {
+		var id identity
+		if err := json.Unmarshal([]byte(value), &id); err != nil {
+			log.Println("Can't read identity", field, "of", name+":", err)
+			continue
+		}
+		identities = append(identities, id)
+	}
+	sort.Slice(identities, func(i, j int) bool {
+		if !identities[i].Verified.Equal(identities[j].Verified) {
+			return identities[i].Verified.After(identities[j].Verified)
+		}
+		return identities[i].ID() < identities[j].ID()
+	})
+	return identities, nil
+}
+
+//addIdentity links id to user name, replacing it if it was already linked
+func addIdentity(name string, id identity) error {
+	value, err := json.Marshal(id)
+	if err != nil {
+		return err
+	}
+	return db.HSet(userKey(name, "identities"), id.ID(), string(value))
+}
+
+//removeIdentity unlinks the identity with ID id from user name
+func removeIdentity(name, id string) error {
+	return db.HDel(userKey(name, "identities"), id)
+}
+
+//matchIdentity returns the identity among identities on this network whose host is hostname. Verified hosts are
+//compared exactly rather than as masks, since a host taken from a connection may contain * or ? or look like a CIDR
+//range.
+func matchIdentity(identities []identity, hostname string) (identity, bool) {
+	for _, id := range identities {
+		if id.Network == network() && id.Host != "" && ircLower(id.Host) == ircLower(hostname) {
+			return id, true
+		}
+	}
+	return identity{}, false
+}
+
+//checkVerified reports whether someone connected from hostname has verified an identity of user uname on this network
+func checkVerified(uname, hostname string) bool {
+	identities, err := userIdentities(uname)
+	if err != nil {
+		log.Println(err.Error())
+		return false
+	}
+	_, found := matchIdentity(identities, hostname)
+	return found
+}
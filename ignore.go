@@ -0,0 +1,201 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+//ignoreKey is the set holding the persistent ignore list
+const ignoreKey = keyPrefix + "ignores"
+
+var (
+	ignoreMutex sync.RWMutex
+	ignoreList  []string                     //hostmasks whose messages are dropped, mirrored in the store
+	knownBots   = make(map[string]time.Time) //lowercased nick -> last time it was seen acting as a bot
+	exchanges   = make(map[string]*exchange) //channel + " " + nick -> recent back and forth with that nick
+	lastSpoke   = make(map[string]time.Time) //lowercased channel or nick -> last time the bot sent it a message
+	botModeChar string                       //user mode marking bots, from the BOT token of RPL_ISUPPORT
+)
+
+//replyWindow is how soon after the bot speaks a message counts as a reply to it. Bots answer within it, people
+//reading what the bot said don't.
+const replyWindow = 2 * time.Second
+
+//exchange counts how many times in a row a nick has triggered the bot in reply to its last message
+type exchange struct {
+	count int
+	last  time.Time
+}
+
+//loadIgnores reads the persistent ignore list from the store
+func loadIgnores() error {
+	masks, err := db.SMembers(ignoreKey)
+	if err != nil {
+		return err
+	}
+	ignoreMutex.Lock()
+	ignoreList = masks
+	ignoreMutex.Unlock()
+	return nil
+}
+
+//addIgnore adds mask to the ignore list, returning false if it was already present
+func addIgnore(mask string) bool {
+	ignoreMutex.Lock()
+	defer ignoreMutex.Unlock()
+	for _, ignored := range ignoreList {
+		if strings.EqualFold(ignored, mask) {
+			return false
+		}
+	}
+	ignoreList = append(ignoreList, mask)
+	if err := db.SAdd(ignoreKey, mask); err != nil {
+		log.Println(err.Error())
+	}
+	return true
+}
+
+//removeIgnore removes mask from the ignore list, returning false if it wasn't present
+func removeIgnore(mask string) bool {
+	ignoreMutex.Lock()
+	defer ignoreMutex.Unlock()
+	for i, ignored := range ignoreList {
+		if strings.EqualFold(ignored, mask) {
+			ignoreList = append(ignoreList[:i], ignoreList[i+1:]...)
+			if err := db.SRem(ignoreKey, ignored); err != nil {
+				log.Println(err.Error())
+			}
+			return true
+		}
+	}
+	return false
+}
+
+//ignores returns a copy of the ignore list
+func ignores() []string {
+	ignoreMutex.RLock()
+	defer ignoreMutex.RUnlock()
+	return append([]string(nil), ignoreList...)
+}
+
+//botMemory is how long a nick is treated as a bot after it was last seen acting as one
+const botMemory = time.Hour
+
+//markBot records that nick identified itself as a bot
+func markBot(nick string) {
+	ignoreMutex.Lock()
+	flagBot(nick, time.Now())
+	ignoreMutex.Unlock()
+}
+
+//flagBot records that nick acted as a bot at now, forgetting nicks that haven't for longer than botMemory. The caller
+//holds ignoreMutex.
+func flagBot(nick string, now time.Time) {
+	for other, seen := range knownBots {
+		if now.Sub(seen) >= botMemory {
+			delete(knownBots, other)
+		}
+	}
+	knownBots[ircLower(nick)] = now
+}
+
+//isIgnored reports whether messages from nick!user@hostname should be dropped, either because the sender matches the
+//ignore list, is the bot itself, or is a bot. tags are the IRCv3 message tags of the message.
+func isIgnored(nick, user, hostname string, tags map[string]string) bool {
+	if ircEqual(nick, config.Nick) {
+		return true
+	}
+	if _, bot := tags["bot"]; bot {
+		markBot(nick)
+		return true
+	}
+	if _, bot := tags["draft/bot"]; bot {
+		markBot(nick)
+		return true
+	}
+	ignoreMutex.RLock()
+	defer ignoreMutex.RUnlock()
+	if seen, bot := knownBots[ircLower(nick)]; bot && time.Since(seen) < botMemory {
+		return true
+	}
+	return matchAny(ignoreList, nick, user, hostname) || matchAny(config.Ignores, nick, user, hostname)
+}
+
+//noteOwnMessage records that the bot sent line to the server, so messages replying to it can be recognised
+func noteOwnMessage(line string) {
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) != 3 || (!strings.EqualFold(fields[0], "PRIVMSG") && !strings.EqualFold(fields[0], "NOTICE")) {
+		return
+	}
+	now := time.Now()
+	ignoreMutex.Lock()
+	defer ignoreMutex.Unlock()
+	for target, spoke := range lastSpoke {
+		if now.Sub(spoke) > replyWindow {
+			delete(lastSpoke, target)
+		}
+	}
+	lastSpoke[ircLower(fields[1])] = now
+}
+
+//inLoop is called whenever nick triggers a reply in channel. It reports whether the bot appears to be stuck talking
+//to another bot: nick has triggered the bot more than config.LoopLimit times in a row, each time in reply to the bot's
+//last message or while tagged as a bot. Once a loop is detected nick is treated as a bot, and ignored, for an hour.
+//People sending commands quickly are left to throttle.
+func inLoop(channel, nick string) bool {
+	limit := withDefault(config.LoopLimit, 3)
+	key := ircLower(channel + " " + nick)
+	replyTarget := channel
+	if ircEqual(channel, config.Nick) { //private messages are answered privately
+		replyTarget = nick
+	}
+	now := time.Now()
+	ignoreMutex.Lock()
+	defer ignoreMutex.Unlock()
+	seen, tagged := knownBots[ircLower(nick)]
+	tagged = tagged && now.Sub(seen) < botMemory
+	if !tagged && now.Sub(lastSpoke[ircLower(replyTarget)]) > replyWindow {
+		delete(exchanges, key)
+		return false
+	}
+	ex, found := exchanges[key]
+	if !found {
+		ex = &exchange{}
+		exchanges[key] = ex
+	}
+	if now.Sub(ex.last) < 5*time.Second {
+		ex.count++
+	} else {
+		ex.count = 0
+	}
+	ex.last = now
+	if ex.count >= limit {
+		log.Printf("Loop detected with %s in %s, ignoring %s\n", nick, channel, nick)
+		flagBot(nick, now)
+		return true
+	}
+	return false
+}
+
+//parseTags splits the IRCv3 message tags off the front of line, returning them along with the rest of the line
+func parseTags(line string) (map[string]string, string) {
+	if !strings.HasPrefix(line, "@") {
+		return nil, line
+	}
+	end := strings.Index(line, " ")
+	if end < 0 {
+		return nil, line
+	}
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(line[1:end], ";") {
+		keyValue := strings.SplitN(tag, "=", 2)
+		if len(keyValue) == 2 {
+			tags[keyValue[0]] = keyValue[1]
+		} else {
+			tags[keyValue[0]] = ""
+		}
+	}
+	return tags, strings.TrimLeft(line[end:], " ")
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -21,21 +22,30 @@ import (
 )
 
 var (
-	funcMap    map[string]command
-	regexpCmds []*regexp.Regexp
-	config     JSONconfig
-	startTime  time.Time
+	funcMap   map[string]command
+	config    JSONconfig
+	startTime time.Time
 )
 
 type JSONconfig struct {
-	Server       string
-	Port         int
-	Nick         string
-	NickServPass string
-	Hostname     string
-	TLS          bool
-	Admins       []string
-	Channels     []string
+	Network         string //name used to separate logs, taken from the server if empty
+	Server          string
+	Port            int
+	Nick            string
+	NickServPass    string
+	Hostname        string
+	TLS             bool
+	Admins          []string
+	Channels        []string
+	ChannelSettings map[string]ChannelConfig //keyed by channel name, "*" applies to all others
+	RateLimit       RateLimitConfig
+	Ignores         []string //hostmasks to ignore in addition to those added with the ignore command
+	LoopLimit       int      //replies in a row to a nick answering the bot's own messages before it's considered a bot (3)
+	UnlinkedNicks   []string //nick patterns not grouped with others on NICK changes (["Guest*"])
+	Log             LogConfig
+	BotLog          BotLogConfig
+	Store           StoreConfig
+	Web             WebConfig
 }
 
 //output err
@@ -71,6 +81,8 @@ func writeToServer(w *bufio.Writer, srvChan chan string, wg *sync.WaitGroup, qui
 		case <-quit: //exit if indicated
 			return
 		case str := <-srvChan:
+			logOutgoing(str)
+			noteOwnMessage(str)
 			_, err = w.WriteString(str + "\r\n")
 			if err == nil {
 				err = w.Flush()
@@ -111,6 +123,10 @@ func writeToConsole(readChan chan string, writeChan chan string, wg *sync.WaitGr
 	questionRegex := regexp.MustCompile(`^:(\S*?)!(\S*?)@(\S*?) PRIVMSG (\S*) :` + config.Nick + `.*\?`)
 	ctcpRegex := regexp.MustCompile(`^:(\S*?)!(\S*?)@(\S*?) PRIVMSG (\S*) :` + "\x01" + `(.*?)` + "\x01" + `$`)
 	inviteRegex := regexp.MustCompile(`^:(\S*)?!(\S*)?@(\S*)? INVITE (` + config.Nick + `) :\s*(.*)`)
+	privmsgRegex := regexp.MustCompile(`^:(\S*?)!(\S*?)@(\S*?) PRIVMSG (\S*) :(.*)`)
+	isupportRegex := regexp.MustCompile(`^:\S+ 005 \S+ (.*?)(?: :.*)?$`)
+	selfJoinRegex := regexp.MustCompile(`^:(\S*?)!\S*? JOIN :?(\S+)`)
+	whoRegex := regexp.MustCompile(`^:\S+ 352 \S+ (\S+) (\S+) (\S+) \S+ (\S+) (\S+)`)
 
 	//read every line from the server chan and print to console
 	for {
@@ -123,6 +139,8 @@ func writeToConsole(readChan chan string, writeChan chan string, wg *sync.WaitGr
 			return
 		case line := <-readChan:
 			log.Println(line)
+			tags, line := parseTags(line)
+			chanLog(line)
 			if match := pingRegex.FindStringSubmatch(line); match != nil {
 				betweenPings = time.Now().Sub(lastPing)
 				lastPing = time.Now()
@@ -131,26 +149,27 @@ func writeToConsole(readChan chan string, writeChan chan string, wg *sync.WaitGr
 				//respond to PING from server
 				writeChan <- ("PONG " + match[1])
 				log.Println("PONG", match[1])
-			} else if match := questionRegex.FindStringSubmatch(line); match != nil {
-				go yesNo(writeChan, match[4], match[1], match[3]) //reply Yes or No if bot was asked a question
+			} else if match := privmsgRegex.FindStringSubmatch(line); match != nil && isIgnored(match[1], match[2], match[3], tags) {
+				//drop messages from ignored users and bots
+			} else if match := questionRegex.FindStringSubmatch(line); match != nil && !channelConfig(match[4]).Quiet &&
+				!inLoop(match[4], match[1]) {
+				go yesNo(writeChan, match[4], match[1], match[2], match[3]) //reply Yes or No if bot was asked a question
 			} else if match := ctcpRegex.FindStringSubmatch(line); match != nil {
-				go ctcp(writeChan, match[4], match[1], match[3], strings.Fields(match[5])) //reply with CTCP if CTCP request was received
+				go ctcp(writeChan, match[4], match[1], match[2], match[3], strings.Fields(match[5])) //reply with CTCP if CTCP request was received
 			} else if match := inviteRegex.FindStringSubmatch(line); match != nil {
 				writeChan <- "JOIN " + match[5]
-			} else {
-				var match []string
-				for _, regexp := range regexpCmds {
-					if match = regexp.FindStringSubmatch(line); match != nil {
-						cmdArgs := strings.Fields(match[5]) //first word is command, the rest (if any) are args for the command
-						if cmd, valid := funcMap[strings.ToLower(cmdArgs[0])]; valid {
-							if match[4] == config.Nick {
-								match[4] = match[1]
-							}
-							go cmd(writeChan, match[4], match[1], match[3], cmdArgs[1:])
-						}
-						break
-					}
+			} else if match := isupportRegex.FindStringSubmatch(line); match != nil {
+				isupport(writeChan, strings.Fields(match[1]))
+			} else if match := selfJoinRegex.FindStringSubmatch(line); match != nil && ircEqual(match[1], config.Nick) {
+				writeChan <- "WHO " + match[2] //learn the hosts of channel members, and which of them are bots
+			} else if match := whoRegex.FindStringSubmatch(line); match != nil {
+				addMember(match[1], match[4], match[2]+"@"+match[3])
+				if botModeChar != "" && strings.Contains(match[5], botModeChar) {
+					markBot(match[4])
 				}
+			} else if match := privmsgRegex.FindStringSubmatch(line); match != nil {
+				noteAccount(match[1], tags["account"])
+				dispatch(writeChan, match[1], match[2], match[3], match[4], match[5])
 			}
 			break
 		case <-pingTimer:
@@ -159,6 +178,64 @@ func writeToConsole(readChan chan string, writeChan chan string, wg *sync.WaitGr
 	}
 }
 
+//dispatch runs the command, if any, in a PRIVMSG sent by nick!user@hostname to target
+func dispatch(srvChan chan string, nick, user, hostname, target, text string) {
+	cmdLine, prefixed, ok := commandLine(target, text)
+	if !ok {
+		return
+	}
+	cmdArgs := strings.Fields(cmdLine) //first word is command, the rest (if any) are args for the command
+	if len(cmdArgs) == 0 {
+		return
+	}
+	channel := target
+	if ircEqual(target, config.Nick) { //reply to private messages privately
+		channel = nick
+	}
+	cmdName := strings.ToLower(cmdArgs[0])
+	cmd, valid := funcMap[cmdName]
+	if !valid {
+		if prefixed { //only suggest for explicit commands, "+comit" but not "yaircb: how are you"
+			go suggest(srvChan, channel, strings.ToLower(cmdArgs[0]))
+		}
+		return
+	}
+	if !commandEnabled(target, cmdName) {
+		return
+	}
+	if inLoop(target, nick) {
+		return
+	}
+	if allowed, notice := throttle(channel, cmdName, nick, user, hostname); !allowed {
+		if notice != "" {
+			message := "NOTICE " + nick + " :" + notice
+			log.Println(message)
+			srvChan <- message
+		}
+		return
+	}
+	go cmd(srvChan, channel, nick, user, hostname, cmdArgs[1:])
+}
+
+//isupport handles the tokens of an RPL_ISUPPORT (005) line
+func isupport(srvChan chan string, tokens []string) {
+	for _, token := range tokens {
+		keyValue := strings.SplitN(token, "=", 2)
+		if len(keyValue) != 2 {
+			continue
+		}
+		switch keyValue[0] {
+		case "CASEMAPPING":
+			caseMapping = strings.ToLower(keyValue[1])
+		case "NETWORK":
+			networkName = keyValue[1]
+		case "BOT":
+			botModeChar = keyValue[1]
+			srvChan <- "MODE " + config.Nick + " +" + botModeChar //identify as a bot
+		}
+	}
+}
+
 //read input from console and send to srvChan
 func readFromConsole(srvChan chan string, wg *sync.WaitGroup, error chan bool, quitChans chan chan bool) {
 	defer wg.Done()
@@ -183,6 +260,13 @@ func readFromConsole(srvChan chan string, wg *sync.WaitGroup, error chan bool, q
 }
 
 func main() {
+	importFormat := flag.String("import", "", "import message counts from channel logs in the given format (irssi, weechat or znc) and exit")
+	importChannel := flag.String("channel", "", "channel the logs given to -import are from, guessed from file names if empty")
+	importNetwork := flag.String("network", "", "network the logs given to -import are from, if config.json doesn't set one")
+	migrate := flag.Bool("migrate", false, "convert the database's keys from older versions of the bot and exit")
+	dryRun := flag.Bool("dryrun", false, "with -migrate, print what would be converted without changing anything")
+	flag.Parse()
+
 	startTime = time.Now()
 	runtime.GOMAXPROCS(4)
 	rand.Seed(time.Now().Unix())
@@ -194,34 +278,70 @@ func main() {
 			log.Fatal("Error unmarshalling config.json")
 		}
 	} else {
-		config = JSONconfig{"chat.freenode.net", 6667, "yaircb", "", "*", false, make([]string, 0), make([]string, 0)}
+		config = JSONconfig{Server: "chat.freenode.net", Port: 6667, Nick: "yaircb", Hostname: "*", Admins: make([]string, 0),
+			Channels: make([]string, 0)}
+	}
+	if err := initBotLog(config.BotLog); err != nil {
+		log.Fatal(err)
 	}
-	fmt.Println(config)
+	shownConfig := config
+	if shownConfig.Store.Password != "" {
+		shownConfig.Store.Password = "(hidden)"
+	}
+	fmt.Println(shownConfig)
 
-	//set up command detection regular expressions
-	regexpCmds = make([]*regexp.Regexp, 3)
-	regexpCmds[0] = regexp.MustCompile(`^:(\S*?)!(\S*?)@(\S*?) PRIVMSG (\S*) :` + config.Nick + `\W?\s*(.*)`)
-	regexpCmds[1] = regexp.MustCompile(`^:(\S*)?!(\S*)?@(\S*)? PRIVMSG (\S*) :\s*\+(.*)`)
-	regexpCmds[2] = regexp.MustCompile(`^:(\S*)?!(\S*)?@(\S*)? PRIVMSG (` + config.Nick + `) :\s*(.*)`)
+	if db, err = openStore(config.Store); err != nil {
+		log.Fatal(err)
+	}
+	if *migrate { //run a migration instead of the bot
+		err = runMigration(*dryRun)
+		db.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := checkSchema(); err != nil {
+		log.Println(err)
+	}
+	if *importFormat != "" { //run an import instead of the bot
+		if *importNetwork != "" {
+			config.Network = *importNetwork
+		}
+		err = runImport(*importFormat, *importChannel, flag.Args())
+		db.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	//initialize global string->function command map
 	funcMap = initMap()
-	err = initCmdRedis()
-	if err != nil { //if redis init fails, print error
+	if err := loadIgnores(); err != nil {
 		log.Println(err)
 	}
+	go pruneLoop()
 
-	if err == nil { //only run web server is redis init doesn't fail
-		//initialize web server
-		initWebRedis()
-		http.Handle("/resources/", http.StripPrefix("/resources/", http.FileServer(http.Dir("resources"))))
-		http.HandleFunc("/register/", registerHandler)
-		http.HandleFunc("/login/", loginHandler)
-		http.HandleFunc("/loginCheck/", loginCheckHandler)
-		http.HandleFunc("/", indexHandler)
-		http.HandleFunc("/save/", saveHandler)
-		http.HandleFunc("/user/", userHandler)
-		go http.ListenAndServeTLS(":8080", "ssl.crt", "ssl.pem", nil)
+	//initialize web server
+	http.Handle("/resources/", http.StripPrefix("/resources/", http.FileServer(http.FS(resourceFiles()))))
+	http.HandleFunc("/register/", registerHandler)
+	http.HandleFunc("/login/", loginHandler)
+	http.HandleFunc("/loginCheck/", loginCheckHandler)
+	http.HandleFunc("/logout/", logoutHandler)
+	http.HandleFunc("/", indexHandler)
+	http.HandleFunc("/save/", saveHandler)
+	http.HandleFunc("/user/", userHandler)
+	http.HandleFunc("/user/unlink/", unlinkHandler)
+	http.HandleFunc("/user/pin/", newPinHandler)
+	http.HandleFunc("/user/password/", passwordHandler)
+	http.HandleFunc("/user/delete/", deleteAccountHandler)
+	http.HandleFunc("/logs/search/", searchHandler)
+	http.HandleFunc("/logs/", logsHandler)
+	http.HandleFunc("/stats/", statsHandler)
+	http.HandleFunc("/export/", exportHandler)
+	if err := startWebServer(renewSessions(http.DefaultServeMux)); err != nil {
+		log.Fatal("Can't start web server: ", err)
 	}
 
 	var conns uint16
@@ -276,7 +396,17 @@ connectionLoop:
 				socketRead = socket.Reader.R
 			}
 			//make writer/reader to/from server
-			//send initial IRC messages, NICK and USER
+			//send initial IRC messages, CAP, NICK and USER
+			//message-tags lets the server mark messages from bots, which are ignored. account-tag marks messages with the
+			//sender's services account, recorded with the identities they verify. It's asked for separately so a server
+			//without it still grants message-tags.
+			_, err = socketWrite.WriteString("CAP REQ :message-tags\r\nCAP REQ :account-tag\r\n")
+			if err == nil {
+				err = socketWrite.Flush()
+			}
+			if err != nil {
+				errOut(err, quitChans)
+			}
 			_, err = socketWrite.WriteString("NICK " + config.Nick + "\r\n")
 			if err == nil {
 				err = socketWrite.Flush()
@@ -293,6 +423,13 @@ connectionLoop:
 			if err != nil {
 				errOut(err, quitChans)
 			}
+			_, err = socketWrite.WriteString("CAP END\r\n")
+			if err == nil {
+				err = socketWrite.Flush()
+			}
+			if err != nil {
+				errOut(err, quitChans)
+			}
 			wgSrv.Add(1)
 			//launch routine to write server output to console
 			rfsQChan := make(chan bool, 1)
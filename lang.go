@@ -0,0 +1,24 @@
+package main
+
+//translations maps a language code to translations of the bot's canned replies, keyed by the English text.
+//Replies without a translation are sent in English.
+var translations = map[string]map[string]string{
+	"de": {
+		"ERROR: Invalid number of arguments":    "FEHLER: Falsche Anzahl von Argumenten",
+		"ERROR: Not enough arguments.":          "FEHLER: Zu wenige Argumente.",
+		"ERROR: Must supply a positive integer": "FEHLER: Eine positive ganze Zahl ist erforderlich",
+		"Yes.":                                  "Ja.",
+		"No.":                                   "Nein.",
+		"Heads.":                                "Kopf.",
+		"Tails.":                                "Zahl.",
+		"Try help <command>. For a list of commands try 'yaircb: commands'": "Versuche help <Befehl>. Eine Liste aller Befehle gibt es mit 'yaircb: commands'",
+	},
+}
+
+//tr translates text into the language configured for channel
+func tr(channel, text string) string {
+	if translated, found := translations[channelConfig(channel).Language][text]; found {
+		return translated
+	}
+	return text
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//logParser parses a single line of a client's log. day holds the date of the file, or of the last date marker seen
+//for formats that mark day changes in the log itself, and may be updated by the parser.
+type logParser func(line string, day *time.Time, loc *time.Location) (logEvent, bool)
+
+//logParsers holds the parser for every importable log format
+var logParsers = map[string]logParser{
+	"irssi":   parseIrssiLine,
+	"weechat": parseWeechatLine,
+	"znc":     parseZNCLine,
+}
+
+var (
+	fileDateRegex = regexp.MustCompile(`(\d{4})-?(\d\d)-?(\d\d)`)
+
+	//WeeChat logs, "2014-03-05 12:34:56\tprefix\tmessage"
+	weechatLineRegex  = regexp.MustCompile(`^(\d{4}-\d\d-\d\d \d\d:\d\d:\d\d)\t([^\t]*)\t(.*)`)
+	weechatJoinRegex  = regexp.MustCompile(`^(\S+) \((\S*)\) has joined`)
+	weechatPartRegex  = regexp.MustCompile(`^(\S+) \((\S*)\) has left \S+(?: \((.*)\))?`)
+	weechatQuitRegex  = regexp.MustCompile(`^(\S+) \((\S*)\) has quit(?: \((.*)\))?`)
+	weechatKickRegex  = regexp.MustCompile(`^(\S+) has kicked (\S+)(?: \((.*)\))?`)
+	weechatNickRegex  = regexp.MustCompile(`^(\S+) is now known as (\S+)`)
+	weechatTopicRegex = regexp.MustCompile(`^(\S+) has changed topic for \S+ (?:from ".*" )?to "(.*)"`)
+
+	//ZNC log module, "[12:34:56] <nick> message"
+	zncPrivmsgRegex = regexp.MustCompile(`^\[(\d\d):(\d\d):\d\d\] <([^>]+)> (.*)`)
+	zncActionRegex  = regexp.MustCompile(`^\[(\d\d):(\d\d):\d\d\] \* (\S+) (.*)`)
+	zncJoinRegex    = regexp.MustCompile(`^\[(\d\d):(\d\d):\d\d\] \*\*\* Joins: (\S+) \((\S*)\)`)
+	zncPartRegex    = regexp.MustCompile(`^\[(\d\d):(\d\d):\d\d\] \*\*\* Parts: (\S+) \((\S*)\) \((.*)\)`)
+	zncQuitRegex    = regexp.MustCompile(`^\[(\d\d):(\d\d):\d\d\] \*\*\* Quits: (\S+) \((\S*)\) \((.*)\)`)
+	zncNickRegex    = regexp.MustCompile(`^\[(\d\d):(\d\d):\d\d\] \*\*\* (\S+) is now known as (\S+)`)
+	zncKickRegex    = regexp.MustCompile(`^\[(\d\d):(\d\d):\d\d\] \*\*\* (\S+) was kicked by (\S+) \((.*)\)`)
+	zncTopicRegex   = regexp.MustCompile(`^\[(\d\d):(\d\d):\d\d\] \*\*\* (\S+) changes topic to '(.*)'`)
+)
+
+//parseWeechatLine parses a line of a WeeChat log. Every line carries its own date, so day is ignored.
+func parseWeechatLine(line string, day *time.Time, loc *time.Location) (logEvent, bool) {
+	match := weechatLineRegex.FindStringSubmatch(line)
+	if match == nil {
+		return logEvent{}, false
+	}
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", match[1], loc)
+	if err != nil {
+		return logEvent{}, false
+	}
+	prefix, text := strings.TrimSpace(match[2]), match[3]
+	event := logEvent{Time: t}
+	switch prefix {
+	case "-->":
+		m := weechatJoinRegex.FindStringSubmatch(text)
+		if m == nil {
+			return logEvent{}, false
+		}
+		event.Type, event.Nick, event.UserHost = "join", m[1], m[2]
+	case "<--":
+		if m := weechatPartRegex.FindStringSubmatch(text); m != nil {
+			event.Type, event.Nick, event.UserHost, event.Text = "part", m[1], m[2], m[3]
+		} else if m := weechatQuitRegex.FindStringSubmatch(text); m != nil {
+			event.Type, event.Nick, event.UserHost, event.Text = "quit", m[1], m[2], m[3]
+		} else if m := weechatKickRegex.FindStringSubmatch(text); m != nil {
+			event.Type, event.Nick, event.Target, event.Text = "kick", m[1], m[2], m[3]
+		} else {
+			return logEvent{}, false
+		}
+	case "--":
+		if m := weechatNickRegex.FindStringSubmatch(text); m != nil {
+			event.Type, event.Nick, event.Target = "nick", m[1], m[2]
+		} else if m := weechatTopicRegex.FindStringSubmatch(text); m != nil {
+			event.Type, event.Nick, event.Text = "topic", m[1], m[2]
+		} else {
+			return logEvent{}, false
+		}
+	case "*":
+		fields := strings.SplitN(text, " ", 2)
+		if len(fields) != 2 {
+			return logEvent{}, false
+		}
+		event.Type, event.Nick, event.Text = "action", fields[0], fields[1]
+	case "", "=!=", "-":
+		return logEvent{}, false //notices and errors
+	default:
+		event.Type, event.Nick, event.Text = "privmsg", strings.TrimLeft(prefix, "@+%&~"), text
+	}
+	return event, true
+}
+
+//parseZNCLine parses a line of a ZNC log. Lines only carry the time, so the date comes from day.
+func parseZNCLine(line string, day *time.Time, loc *time.Location) (logEvent, bool) {
+	var event logEvent
+	var match []string
+	if match = zncPrivmsgRegex.FindStringSubmatch(line); match != nil {
+		event = logEvent{Type: "privmsg", Nick: strings.TrimLeft(match[3], "@+%&~"), Text: match[4]}
+	} else if match = zncJoinRegex.FindStringSubmatch(line); match != nil {
+		event = logEvent{Type: "join", Nick: match[3], UserHost: match[4]}
+	} else if match = zncPartRegex.FindStringSubmatch(line); match != nil {
+		event = logEvent{Type: "part", Nick: match[3], UserHost: match[4], Text: match[5]}
+	} else if match = zncQuitRegex.FindStringSubmatch(line); match != nil {
+		event = logEvent{Type: "quit", Nick: match[3], UserHost: match[4], Text: match[5]}
+	} else if match = zncNickRegex.FindStringSubmatch(line); match != nil {
+		event = logEvent{Type: "nick", Nick: match[3], Target: match[4]}
+	} else if match = zncKickRegex.FindStringSubmatch(line); match != nil {
+		event = logEvent{Type: "kick", Nick: match[4], Target: match[3], Text: match[5]}
+	} else if match = zncTopicRegex.FindStringSubmatch(line); match != nil {
+		event = logEvent{Type: "topic", Nick: match[3], Text: match[4]}
+	} else if match = zncActionRegex.FindStringSubmatch(line); match != nil {
+		event = logEvent{Type: "action", Nick: match[3], Text: match[4]}
+	} else {
+		return logEvent{}, false
+	}
+	var hour, minute int
+	fmt.Sscanf(match[1]+" "+match[2], "%d %d", &hour, &minute)
+	event.Time = time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, loc)
+	return event, true
+}
+
+//channelFromPath guesses the channel a log file belongs to from its name: #chan.log (irssi),
+//irc.network.#chan.weechatlog (WeeChat), #chan/2014-03-05.log or #chan_20140305.log (ZNC)
+func channelFromPath(path string) string {
+	base := filepath.Base(path)
+	if i := strings.IndexAny(base, "#&"); i >= 0 {
+		name := base[i:]
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+		if match := fileDateRegex.FindStringIndex(name); match != nil && match[0] > 0 && name[match[0]-1] == '_' {
+			name = name[:match[0]-1] //ZNC's #chan_20140305
+		}
+		return name
+	}
+	if parent := filepath.Base(filepath.Dir(path)); strings.IndexAny(parent, "#&") == 0 {
+		return parent
+	}
+	return ""
+}
+
+//parseLogFile reads the log at path in format, calling fn for every event
+func parseLogFile(format, path string, loc *time.Location, fn func(logEvent)) error {
+	parser, found := logParsers[format]
+	if !found {
+		return errors.New("Unknown log format '" + format + "'")
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var day time.Time
+	if match := fileDateRegex.FindStringSubmatch(filepath.Base(path)); match != nil {
+		day, _ = time.ParseInLocation("20060102", match[1]+match[2]+match[3], loc)
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if event, ok := parser(scanner.Text(), &day, loc); ok {
+			fn(event)
+		}
+	}
+	return scanner.Err()
+}
+
+//backfill stores per day message counts (day -> lowercased nick -> count) imported for channel. They replace the counts
+//imported before for the same nick and day, so importing a log twice doesn't count it twice, but are kept apart from
+//the counts of messages the bot saw itself.
+func backfill(channel string, counts map[string]map[string]int) error {
+	allKey := statsKey(channel, "all")
+	for day, nicks := range counts {
+		dayKey := statsKey(channel, day)
+		for nick, count := range nicks {
+			field := importedPrefix + nick
+			oldCount, _, err := db.HGet(dayKey, field)
+			if err != nil {
+				return err
+			}
+			old, err := strconv.Atoi(oldCount)
+			if err != nil {
+				old = 0
+			}
+			if err := db.HSet(dayKey, field, strconv.Itoa(count)); err != nil {
+				return err
+			}
+			if _, err := db.HIncrBy(allKey, field, int64(count-old)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//runImport imports message counts from the logs in paths, written by a client using format. If channel is empty it's
+//guessed from each file's name. Counts from every file are added up before they're stored, so all the logs of a
+//channel, e.g. rotated files or logs from more than one client, should be imported together.
+func runImport(format, channel string, paths []string) error {
+	if len(paths) == 0 {
+		return errors.New("No log files given")
+	}
+	//without a connection the network can only be guessed from the server name, which may not match the name the
+	//server gives itself and the bot's own stats are kept under
+	if config.Network == "" {
+		return errors.New("Set Network in config.json or use -network to say which network the logs are from")
+	}
+	counts := make(map[string]map[string]map[string]int) //channel -> day -> lowercased nick -> count
+	for _, path := range paths {
+		fileChannel := channel
+		if fileChannel == "" {
+			fileChannel = channelFromPath(path)
+		}
+		if fileChannel == "" {
+			return errors.New("Can't tell which channel " + path + " is from, use -channel")
+		}
+		if counts[fileChannel] == nil {
+			counts[fileChannel] = make(map[string]map[string]int)
+		}
+		dayCounts := counts[fileChannel]
+		messages := 0
+		days := make(map[string]bool)
+		err := parseLogFile(format, path, time.Local, func(event logEvent) {
+			if event.Type != "privmsg" || event.Time.Year() < 1990 {
+				return
+			}
+			day := event.Time.Format("2006-01-02")
+			if dayCounts[day] == nil {
+				dayCounts[day] = make(map[string]int)
+			}
+			dayCounts[day][ircLower(event.Nick)]++
+			days[day] = true
+			messages++
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: read %d messages over %d days from %s/%s\n", path, messages, len(days), network(), fileChannel)
+	}
+	for fileChannel, dayCounts := range counts {
+		if err := backfill(fileChannel, dayCounts); err != nil {
+			return err
+		}
+		fmt.Printf("Imported %d days into %s/%s\n", len(dayCounts), network(), fileChannel)
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//BotLogConfig controls where the bot's own log (every raw line and message it prints) is written
+type BotLogConfig struct {
+	File     string //file to log to, stdout if empty
+	Daily    bool   //start a new file every day
+	MaxSize  int    //start a new file once the current one reaches this many megabytes, 0 for no limit
+	Compress bool   //gzip rotated files
+	MaxFiles int    //rotated files to keep, 0 to keep them all
+}
+
+//logSink is the output of the standard logger. It drops lines mentioning channels that aren't logged, and writes the
+//rest to stdout or to a file that's rotated as configured.
+type logSink struct {
+	mutex   sync.Mutex
+	cleanup sync.Mutex //held while compressing and removing rotated files
+	conf    BotLogConfig
+	file    *os.File
+	size    int64
+	day     string //day the current file was started
+}
+
+//initBotLog points the standard logger at a logSink for conf
+func initBotLog(conf BotLogConfig) error {
+	sink := &logSink{conf: conf}
+	if conf.File != "" {
+		if err := sink.open(); err != nil {
+			return err
+		}
+	}
+	log.SetOutput(sink)
+	return nil
+}
+
+//open opens the sink's file for appending
+func (sink *logSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(sink.conf.File), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(sink.conf.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	sink.file, sink.size = file, stat.Size()
+	sink.day = stat.ModTime().Format("2006-01-02") //so a file left over from yesterday is rotated on the first write
+	if stat.Size() == 0 {
+		sink.day = time.Now().Format("2006-01-02")
+	}
+	return nil
+}
+
+//Write writes a single line from the logger
+func (sink *logSink) Write(p []byte) (int, error) {
+	if mentionsUnlogged(string(p)) {
+		return len(p), nil
+	}
+	if sink.conf.File == "" {
+		return os.Stdout.Write(p)
+	}
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	today := time.Now().Format("2006-01-02")
+	if (sink.conf.Daily && today != sink.day) ||
+		(sink.conf.MaxSize > 0 && sink.size+int64(len(p)) > int64(sink.conf.MaxSize)*1024*1024 && sink.size > 0) {
+		if err := sink.rotate(); err != nil {
+			os.Stderr.WriteString("Rotating log failed: " + err.Error() + "\n")
+		}
+	}
+	n, err := sink.file.Write(p)
+	sink.size += int64(n)
+	return n, err
+}
+
+//rotate moves the current file aside, compressing it and removing old files as configured, and starts a new one
+func (sink *logSink) rotate() error {
+	sink.file.Close()
+	rotated := sink.conf.File + "." + time.Now().Format("2006-01-02T15-04-05.000")
+	for i := 1; fileExists(rotated) || fileExists(rotated+".gz"); i++ { //rotated twice within a millisecond
+		rotated = sink.conf.File + "." + time.Now().Format("2006-01-02T15-04-05.000") + "-" + strconv.Itoa(i)
+	}
+	if err := os.Rename(sink.conf.File, rotated); err != nil {
+		sink.open() //keep logging to the old file
+		return err
+	}
+	if err := sink.open(); err != nil {
+		return err
+	}
+	go func() {
+		sink.cleanup.Lock()
+		defer sink.cleanup.Unlock()
+		if sink.conf.Compress {
+			if err := gzipFile(rotated); err != nil && !os.IsNotExist(err) { //not if already removed as surplus
+				os.Stderr.WriteString("Compressing log failed: " + err.Error() + "\n")
+			}
+		}
+		removeOldLogs(sink.conf.File, sink.conf.MaxFiles)
+	}()
+	return nil
+}
+
+//fileExists reports whether there's a file at path
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+//gzipFile compresses the file at path to path.gz and removes the original
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	writer := gzip.NewWriter(out)
+	if _, err := io.Copy(writer, in); err != nil {
+		out.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		out.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+//removeOldLogs removes the oldest files rotated from path, keeping the newest keep. keep 0 keeps them all.
+func removeOldLogs(path string, keep int) {
+	if keep <= 0 {
+		return
+	}
+	rotated, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return
+	}
+	sort.Strings(rotated) //names end in the time they were rotated, so this is oldest first
+	for len(rotated) > keep {
+		os.Remove(rotated[0])
+		rotated = rotated[1:]
+	}
+}
+
+//mentionsUnlogged reports whether line names a channel whose settings have NoLog set
+func mentionsUnlogged(line string) bool {
+	for _, field := range strings.Fields(line) {
+		for _, name := range strings.Split(strings.TrimLeft(field, ":"), ",") {
+			if isChannel(name) && channelConfig(name).NoLog {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//pruneLog removes the days before cutoff (formatted 2006-01-02) from channel's log, removing the log if nothing is
+//left
+func pruneLog(channel, cutoff string) error {
+	path := logPath(channel)
+	index, err := indexDays(path)
+	if err != nil {
+		return err
+	}
+	if len(index.days) == 0 {
+		return nil
+	}
+	keepFrom := ""
+	for _, day := range index.days {
+		if day >= cutoff {
+			keepFrom = day
+			break
+		}
+	}
+	if keepFrom == index.days[0] {
+		return nil //nothing to remove
+	}
+
+	logMutex.Lock() //stop writes while the log is replaced
+	defer logMutex.Unlock()
+	if logFile, found := logFiles[path]; found {
+		logFile.file.Close()
+		delete(logFiles, path)
+	}
+	defer func() {
+		dayIndexMutex.Lock()
+		delete(dayIndexes, path)
+		dayIndexMutex.Unlock()
+	}()
+	if keepFrom == "" {
+		return os.Remove(path)
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if _, err := in.Seek(index.offsets[keepFrom], 0); err != nil {
+		return err
+	}
+	out, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	//the marker that dated the first kept line was before its offset, so start with one of our own
+	dayTime, _ := time.ParseInLocation("2006-01-02", keepFrom, time.Local)
+	out.WriteString("--- Log opened " + dayTime.Format("Mon Jan 02 15:04:05 2006") + "\n")
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(path + ".tmp")
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+//pruneLogs applies the RetentionDays of every logged channel's settings to its log and search index
+func pruneLogs() {
+	for _, channel := range loggedChannels() {
+		days := channelConfig(channel).RetentionDays
+		if days <= 0 {
+			continue
+		}
+		cutoff := time.Now().AddDate(0, 0, -days)
+		if err := pruneLog(channel, cutoff.Format("2006-01-02")); err != nil {
+			log.Println(err.Error())
+		}
+		if err := pruneIndex(channel, cutoff); err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
+
+//pruneLoop runs pruneLogs and pruneSessions once an hour
+func pruneLoop() {
+	for {
+		pruneLogs()
+		pruneSessions()
+		time.Sleep(time.Hour)
+	}
+}
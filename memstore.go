@@ -0,0 +1,483 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//storeOp is a single change to a memoryStore. The file backend journals them so they can be replayed.
+type storeOp struct {
+	Op      string    `json:"op"`
+	Key     string    `json:"key"`
+	Field   string    `json:"field,omitempty"`
+	Values  []string  `json:"values,omitempty"`
+	Score   float64   `json:"score,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+//memoryStore keeps the bot's data in memory. Nothing survives a restart unless it's journaled by a fileStore.
+type memoryStore struct {
+	mutex   sync.Mutex
+	strings map[string]string
+	hashes  map[string]map[string]string
+	sets    map[string]map[string]bool
+	zsets   map[string]map[string]float64
+	expires map[string]time.Time
+	swept   time.Time              //when expired keys were last removed by sweep
+	journal func(op storeOp) error //called with every change, if not nil
+}
+
+//sweepInterval is how often using a memoryStore also removes every expired key, so keys that are never read again
+//don't pile up
+const sweepInterval = time.Minute
+
+//newMemoryStore returns an empty memoryStore
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		strings: make(map[string]string),
+		hashes:  make(map[string]map[string]string),
+		sets:    make(map[string]map[string]bool),
+		zsets:   make(map[string]map[string]float64),
+		expires: make(map[string]time.Time),
+	}
+}
+
+var errWrongType = errors.New("Operation against a key holding the wrong kind of value")
+
+//expire removes key if it has expired, and every other expired key once every sweepInterval. The caller holds the
+//mutex.
+func (store *memoryStore) expire(key string) {
+	if time.Since(store.swept) >= sweepInterval {
+		store.sweep()
+	}
+	if expires, found := store.expires[key]; found && !time.Now().Before(expires) {
+		store.remove(key)
+	}
+}
+
+//sweep removes every key that has expired. The caller holds the mutex.
+func (store *memoryStore) sweep() {
+	now := time.Now()
+	for key, expires := range store.expires {
+		if !now.Before(expires) {
+			store.remove(key)
+		}
+	}
+	store.swept = now
+}
+
+//remove removes key of whatever kind. The caller holds the mutex.
+func (store *memoryStore) remove(key string) {
+	delete(store.strings, key)
+	delete(store.hashes, key)
+	delete(store.sets, key)
+	delete(store.zsets, key)
+	delete(store.expires, key)
+}
+
+//exists reports whether key holds a value. The caller holds the mutex.
+func (store *memoryStore) exists(key string) bool {
+	store.expire(key)
+	_, isString := store.strings[key]
+	return isString || store.hashes[key] != nil || store.sets[key] != nil || store.zsets[key] != nil
+}
+
+//apply makes the change op describes and journals it. The caller holds the mutex.
+func (store *memoryStore) apply(op storeOp) error {
+	switch op.Op {
+	case "set":
+		store.remove(op.Key)
+		store.strings[op.Key] = op.Values[0]
+		if !op.Expires.IsZero() {
+			store.expires[op.Key] = op.Expires
+		}
+	case "del":
+		store.remove(op.Key)
+	case "expire":
+		store.expires[op.Key] = op.Expires
+	case "rename":
+		newKey := op.Values[0]
+		if newKey == op.Key {
+			break
+		}
+		store.remove(newKey)
+		if value, found := store.strings[op.Key]; found {
+			store.strings[newKey] = value
+		}
+		if store.hashes[op.Key] != nil {
+			store.hashes[newKey] = store.hashes[op.Key]
+		}
+		if store.sets[op.Key] != nil {
+			store.sets[newKey] = store.sets[op.Key]
+		}
+		if store.zsets[op.Key] != nil {
+			store.zsets[newKey] = store.zsets[op.Key]
+		}
+		if expires, found := store.expires[op.Key]; found {
+			store.expires[newKey] = expires
+		}
+		store.remove(op.Key)
+	case "hset":
+		if store.hashes[op.Key] == nil {
+			store.hashes[op.Key] = make(map[string]string)
+		}
+		store.hashes[op.Key][op.Field] = op.Values[0]
+	case "hdel":
+		for _, field := range op.Values {
+			delete(store.hashes[op.Key], field)
+		}
+		if len(store.hashes[op.Key]) == 0 {
+			store.remove(op.Key)
+		}
+	case "sadd":
+		if store.sets[op.Key] == nil {
+			store.sets[op.Key] = make(map[string]bool)
+		}
+		for _, member := range op.Values {
+			store.sets[op.Key][member] = true
+		}
+	case "srem":
+		for _, member := range op.Values {
+			delete(store.sets[op.Key], member)
+		}
+		if len(store.sets[op.Key]) == 0 {
+			store.remove(op.Key)
+		}
+	case "zadd":
+		if store.zsets[op.Key] == nil {
+			store.zsets[op.Key] = make(map[string]float64)
+		}
+		store.zsets[op.Key][op.Values[0]] = op.Score
+	case "zrem":
+		for _, member := range op.Values {
+			delete(store.zsets[op.Key], member)
+		}
+		if len(store.zsets[op.Key]) == 0 {
+			store.remove(op.Key)
+		}
+	default:
+		return errors.New("Unknown store operation '" + op.Op + "'")
+	}
+	if store.journal != nil {
+		return store.journal(op)
+	}
+	return nil
+}
+
+//check returns errWrongType if key holds something other than kind ("string", "hash", "set" or "zset"). The caller
+//holds the mutex.
+func (store *memoryStore) check(key, kind string) error {
+	if !store.exists(key) {
+		return nil
+	}
+	_, isString := store.strings[key]
+	if (kind == "string") != isString || (kind == "hash") != (store.hashes[key] != nil) ||
+		(kind == "set") != (store.sets[key] != nil) || (kind == "zset") != (store.zsets[key] != nil) {
+		return errWrongType
+	}
+	return nil
+}
+
+func (store *memoryStore) Get(key string) (string, bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := store.check(key, "string"); err != nil {
+		return "", false, err
+	}
+	value, found := store.strings[key]
+	return value, found, nil
+}
+
+func (store *memoryStore) Set(key, value string, ttl time.Duration) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	op := storeOp{Op: "set", Key: key, Values: []string{value}}
+	if ttl > 0 {
+		op.Expires = time.Now().Add(ttl)
+	}
+	return store.apply(op)
+}
+
+func (store *memoryStore) SetNX(key, value string) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if store.exists(key) {
+		return false, nil
+	}
+	return true, store.apply(storeOp{Op: "set", Key: key, Values: []string{value}})
+}
+
+func (store *memoryStore) Del(keys ...string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for _, key := range keys {
+		if store.exists(key) {
+			if err := store.apply(storeOp{Op: "del", Key: key}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (store *memoryStore) Expire(key string, ttl time.Duration) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if !store.exists(key) {
+		return nil
+	}
+	return store.apply(storeOp{Op: "expire", Key: key, Expires: time.Now().Add(ttl)})
+}
+
+func (store *memoryStore) Incr(key string) (int64, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := store.check(key, "string"); err != nil {
+		return 0, err
+	}
+	n := int64(0)
+	if value, found := store.strings[key]; found {
+		var err error
+		if n, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return 0, errors.New("Value is not an integer")
+		}
+	}
+	n++
+	op := storeOp{Op: "set", Key: key, Values: []string{strconv.FormatInt(n, 10)}, Expires: store.expires[key]}
+	return n, store.apply(op)
+}
+
+func (store *memoryStore) Keys(prefix string) ([]string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	var keys []string
+	add := func(key string) {
+		if strings.HasPrefix(key, prefix) && store.exists(key) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range store.strings {
+		add(key)
+	}
+	for key := range store.hashes {
+		add(key)
+	}
+	for key := range store.sets {
+		add(key)
+	}
+	for key := range store.zsets {
+		add(key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (store *memoryStore) Rename(key, newKey string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if !store.exists(key) {
+		return errors.New("No such key")
+	}
+	return store.apply(storeOp{Op: "rename", Key: key, Values: []string{newKey}})
+}
+
+func (store *memoryStore) HGet(key, field string) (string, bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := store.check(key, "hash"); err != nil {
+		return "", false, err
+	}
+	value, found := store.hashes[key][field]
+	return value, found, nil
+}
+
+func (store *memoryStore) HSet(key, field, value string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := store.check(key, "hash"); err != nil {
+		return err
+	}
+	return store.apply(storeOp{Op: "hset", Key: key, Field: field, Values: []string{value}})
+}
+
+func (store *memoryStore) HDel(key string, fields ...string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := store.check(key, "hash"); err != nil || store.hashes[key] == nil {
+		return err
+	}
+	return store.apply(storeOp{Op: "hdel", Key: key, Values: fields})
+}
+
+func (store *memoryStore) HGetAll(key string) (map[string]string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := store.check(key, "hash"); err != nil {
+		return nil, err
+	}
+	hash := make(map[string]string, len(store.hashes[key]))
+	for field, value := range store.hashes[key] {
+		hash[field] = value
+	}
+	return hash, nil
+}
+
+func (store *memoryStore) HIncrBy(key, field string, n int64) (int64, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := store.check(key, "hash"); err != nil {
+		return 0, err
+	}
+	old := int64(0)
+	if value, found := store.hashes[key][field]; found {
+		var err error
+		if old, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return 0, errors.New("Hash value is not an integer")
+		}
+	}
+	return old + n, store.apply(storeOp{Op: "hset", Key: key, Field: field,
+		Values: []string{strconv.FormatInt(old+n, 10)}})
+}
+
+func (store *memoryStore) SAdd(key string, members ...string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := store.check(key, "set"); err != nil {
+		return err
+	}
+	return store.apply(storeOp{Op: "sadd", Key: key, Values: members})
+}
+
+func (store *memoryStore) SRem(key string, members ...string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := store.check(key, "set"); err != nil || store.sets[key] == nil {
+		return err
+	}
+	return store.apply(storeOp{Op: "srem", Key: key, Values: members})
+}
+
+func (store *memoryStore) SIsMember(key, member string) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := store.check(key, "set"); err != nil {
+		return false, err
+	}
+	return store.sets[key][member], nil
+}
+
+func (store *memoryStore) SMembers(key string) ([]string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := store.check(key, "set"); err != nil {
+		return nil, err
+	}
+	members := make([]string, 0, len(store.sets[key]))
+	for member := range store.sets[key] {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+func (store *memoryStore) ZAdd(key string, score float64, member string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := store.check(key, "zset"); err != nil {
+		return err
+	}
+	return store.apply(storeOp{Op: "zadd", Key: key, Score: score, Values: []string{member}})
+}
+
+func (store *memoryStore) ZRangeByScore(key string, max float64) ([]string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := store.check(key, "zset"); err != nil {
+		return nil, err
+	}
+	var members []string
+	for member, score := range store.zsets[key] {
+		if score < max {
+			members = append(members, member)
+		}
+	}
+	zset := store.zsets[key]
+	sort.Slice(members, func(i, j int) bool {
+		if zset[members[i]] != zset[members[j]] {
+			return zset[members[i]] < zset[members[j]]
+		}
+		return members[i] < members[j]
+	})
+	return members, nil
+}
+
+func (store *memoryStore) ZRemRangeByScore(key string, max float64) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if err := store.check(key, "zset"); err != nil {
+		return err
+	}
+	var members []string
+	for member, score := range store.zsets[key] {
+		if score < max {
+			members = append(members, member)
+		}
+	}
+	if len(members) == 0 {
+		return nil
+	}
+	return store.apply(storeOp{Op: "zrem", Key: key, Values: members})
+}
+
+func (store *memoryStore) ZInterRevRange(keys []string, offset, count int) ([]string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	for _, key := range keys {
+		if err := store.check(key, "zset"); err != nil {
+			return nil, err
+		}
+	}
+	type scored struct {
+		member string
+		score  float64
+	}
+	var matches []scored
+	for member, score := range store.zsets[keys[0]] {
+		inAll := true
+		for _, key := range keys[1:] {
+			other, found := store.zsets[key][member]
+			if !found {
+				inAll = false
+				break
+			}
+			if other > score {
+				score = other
+			}
+		}
+		if inAll {
+			matches = append(matches, scored{member, score})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].member > matches[j].member
+	})
+	var members []string
+	for i := offset; i < len(matches) && i < offset+count; i++ {
+		members = append(members, matches[i].member)
+	}
+	return members, nil
+}
+
+func (store *memoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+//legacyPrefixes start the keys, other than users' keys, that the bot stored before keys were namespaced
+var legacyPrefixes = []string{"aliases:", "person:", "masks:", "search:", "seen:", "stats:", "export:"}
+
+//legacyUserParts maps the suffixes of users' keys from before keys were namespaced to the parts userKey takes now, or
+//to "" for parts that are no longer kept. A user's password hash was stored at their bare name.
+var legacyUserParts = map[string]string{"Pin": "pin", "Host": "host", "Nick": "nick", "Cookie": ""}
+
+//migration is the work needed to bring a database up to schemaVersion
+type migration struct {
+	renames  [][2]string //old key, new key
+	deletes  []string
+	converts []string //users whose single verified host and nick become an identity
+	problems []string //keys that can't be migrated unambiguously, and what's done with them
+}
+
+//planMigration works out how to move keys, the keys in a database, to the current schema
+func planMigration(keys []string) migration {
+	var plan migration
+	exists := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		exists[key] = true
+	}
+	//every registered user was given a PIN, so a user's password hash is at a key with a Pin key next to it. Anything
+	//else is a part of a user's account if its name is a user's name and a known suffix.
+	isUser := func(key string) bool {
+		return exists[key] && exists[key+"Pin"]
+	}
+	sort.Strings(keys)
+keys:
+	for _, key := range keys {
+		if strings.HasPrefix(key, keyPrefix) {
+			continue
+		}
+		if key == "ignores" {
+			plan.renames = append(plan.renames, [2]string{key, ignoreKey})
+			continue
+		}
+		if strings.HasPrefix(key, "zinter:") { //cached search results, redone as needed
+			plan.deletes = append(plan.deletes, key)
+			continue
+		}
+		for _, prefix := range legacyPrefixes {
+			if strings.HasPrefix(key, prefix) {
+				plan.renames = append(plan.renames, [2]string{key, keyPrefix + key})
+				continue keys
+			}
+		}
+		for suffix, part := range legacyUserParts {
+			name := strings.TrimSuffix(key, suffix)
+			if name == key || !isUser(name) {
+				continue
+			}
+			fate := "kept as " + name + "'s " + part
+			if part == "" {
+				plan.deletes = append(plan.deletes, key)
+				part, fate = "old session", "deleted"
+			} else {
+				plan.renames = append(plan.renames, [2]string{key, userKey(name, part)})
+			}
+			if isUser(key) {
+				plan.problems = append(plan.problems, "'"+key+"' is both "+name+"'s "+part+" and user "+key+
+					"'s password; "+fate+", "+key+" will have to register again")
+			}
+			continue keys
+		}
+		if isUser(key) {
+			plan.renames = append(plan.renames, [2]string{key, userKey(key, "password")})
+			continue
+		}
+		plan.problems = append(plan.problems, "'"+key+"' isn't a key the bot knows; left alone")
+	}
+	for _, rename := range plan.renames {
+		if exists[rename[1]] {
+			plan.problems = append(plan.problems, "'"+rename[1]+"' already exists; replaced with '"+rename[0]+"'")
+		}
+	}
+	converting := make(map[string]bool) //a user's host key may both exist and be the target of a rename
+	for _, key := range keys {
+		if name, found := legacyVerification(key); found {
+			converting[name] = true
+		}
+	}
+	for _, rename := range plan.renames {
+		if name, found := legacyVerification(rename[1]); found {
+			converting[name] = true
+		}
+	}
+	for name := range converting {
+		plan.converts = append(plan.converts, name)
+	}
+	sort.Strings(plan.converts)
+	return plan
+}
+
+//legacyVerification returns the user whose verified host, from before users could verify more than one identity, is
+//kept at key
+func legacyVerification(key string) (name string, found bool) {
+	if !strings.HasPrefix(key, keyPrefix+"user:") || !strings.HasSuffix(key, ":host") {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(key, keyPrefix+"user:"), ":host"), true
+}
+
+//convertVerification turns user name's verified host and nick!user into an identity on this network
+func convertVerification(name string) error {
+	host, found, err := db.Get(userKey(name, "host"))
+	if err != nil || !found {
+		return err
+	}
+	nickUser, _, err := db.Get(userKey(name, "nick"))
+	if err != nil {
+		return err
+	}
+	id := identity{Network: network(), Host: host}
+	nickAndUser := strings.SplitN(nickUser, "!", 2)
+	id.Nick = nickAndUser[0]
+	if len(nickAndUser) == 2 {
+		id.User = nickAndUser[1]
+	}
+	if err := addIdentity(name, id); err != nil {
+		return err
+	}
+	if err := db.Del(userKey(name, "host")); err != nil {
+		return err
+	}
+	return db.Del(userKey(name, "nick"))
+}
+
+//runMigration moves every key in the database to the current schema, or with dryRun just prints what it would do
+func runMigration(dryRun bool) error {
+	keys, err := db.Keys("")
+	if err != nil {
+		return err
+	}
+	plan := planMigration(keys)
+	for _, rename := range plan.renames {
+		fmt.Println("rename", rename[0], "->", rename[1])
+		if !dryRun {
+			if err := db.Rename(rename[0], rename[1]); err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range plan.deletes {
+		fmt.Println("delete", key)
+		if !dryRun {
+			if err := db.Del(key); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range plan.converts {
+		fmt.Println("convert", userKey(name, "host"), "and", userKey(name, "nick"), "->", userKey(name, "identities"))
+		if !dryRun {
+			if err := convertVerification(name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, problem := range plan.problems {
+		fmt.Println("warning:", problem)
+	}
+	fmt.Printf("%d keys renamed, %d deleted, %d users' identities converted, %d warnings\n", len(plan.renames),
+		len(plan.deletes), len(plan.converts), len(plan.problems))
+	if dryRun {
+		fmt.Println("Dry run, nothing was changed")
+		return nil
+	}
+	return db.Set(schemaKey, schemaVersion, 0)
+}
+
+//checkSchema warns if the database holds keys from before keys were namespaced or users could verify more than one
+//identity, which the bot no longer reads. A database without any is marked as using the current schema, so it's only
+//searched once.
+func checkSchema() error {
+	version, found, err := db.Get(schemaKey)
+	if err != nil {
+		return err
+	}
+	if found {
+		if version != schemaVersion {
+			log.Println("Database schema is version", version, "but this bot uses version", schemaVersion+
+				", run it with -migrate to convert it")
+		}
+		return nil
+	}
+	keys, err := db.Keys("")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, found := legacyVerification(key); found || !strings.HasPrefix(key, keyPrefix) {
+			log.Println("Database has keys from an older version of the bot, run it with -migrate to convert them")
+			return nil
+		}
+	}
+	return db.Set(schemaKey, schemaVersion, 0)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+//RateLimitConfig controls how often commands may be used. Zero values use the defaults in the comments.
+type RateLimitConfig struct {
+	Cooldowns     map[string]int //seconds between uses of a command in a channel, keyed by command; "*" for all others
+	UserBurst     int            //commands a user may send in a row before being throttled (5)
+	UserRefill    int            //seconds for a user to earn back one command (5)
+	Strikes       int            //throttled commands within ten minutes before a user is ignored (3)
+	IgnoreSeconds int            //length of the first ignore, doubled for every ignore after it (60)
+}
+
+//bucket tracks one user's command budget and offences
+type bucket struct {
+	tokens       float64
+	lastRefill   time.Time
+	strikes      int
+	lastStrike   time.Time
+	level        uint //number of times the user has been ignored, decays an hour after the last ignore ends
+	ignoredUntil time.Time
+	lastNotice   time.Time
+}
+
+var (
+	rateMutex sync.Mutex
+	lastUse   = make(map[string]time.Time) //channel + " " + command -> last use
+	buckets   = make(map[string]*bucket)   //user@host -> bucket
+	lastPrune time.Time                    //when buckets and lastUse were last pruned
+)
+
+//maxIgnore is the longest a user is ignored for, however many times they've been ignored before
+const maxIgnore = 7 * 24 * time.Hour
+
+//withDefault returns value, or def if value isn't positive
+func withDefault(value, def int) int {
+	if value > 0 {
+		return value
+	}
+	return def
+}
+
+//cooldown returns how long cmdName must rest between uses in a channel
+func cooldown(cmdName string) time.Duration {
+	seconds, found := config.RateLimit.Cooldowns[cmdName]
+	if !found {
+		seconds = config.RateLimit.Cooldowns["*"]
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+//pruneRateLimits forgets users whose buckets have refilled and whose offences have been forgiven, and uses whose
+//cooldowns have passed, so only recent activity is kept. rateMutex must be held.
+func pruneRateLimits(now time.Time, burst float64, refill time.Duration) {
+	for key, b := range buckets {
+		full := b.tokens+float64(now.Sub(b.lastRefill))/float64(refill) >= burst
+		if full && now.Sub(b.ignoredUntil) > time.Hour && now.Sub(b.lastStrike) > 10*time.Minute {
+			delete(buckets, key)
+		}
+	}
+	for key, used := range lastUse {
+		if now.Sub(used) >= cooldown(key[strings.LastIndex(key, " ")+1:]) {
+			delete(lastUse, key)
+		}
+	}
+}
+
+//throttle decides whether nick!user@hostname may run cmdName in channel right now, and records the use if so.
+//When the command is refused, notice holds a message for the user, or is empty if they shouldn't be told (because they
+//are being ignored or were told recently).
+func throttle(channel, cmdName, nick, user, hostname string) (allowed bool, notice string) {
+	if isAdmin(nick, user, hostname) {
+		return true, ""
+	}
+	rateMutex.Lock()
+	defer rateMutex.Unlock()
+
+	now := time.Now()
+	burst := float64(withDefault(config.RateLimit.UserBurst, 5))
+	refill := time.Duration(withDefault(config.RateLimit.UserRefill, 5)) * time.Second
+	if now.Sub(lastPrune) > time.Minute {
+		pruneRateLimits(now, burst, refill)
+		lastPrune = now
+	}
+	key := strings.ToLower(user + "@" + hostname)
+	b, found := buckets[key]
+	if !found {
+		b = &bucket{tokens: burst, lastRefill: now}
+		buckets[key] = b
+	}
+	if now.Before(b.ignoredUntil) {
+		return false, ""
+	}
+	b.tokens += float64(now.Sub(b.lastRefill)) / float64(refill)
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.lastRefill = now
+
+	useKey := ircLower(channel) + " " + cmdName
+	if b.tokens < 1 {
+		notice = "You're sending commands too quickly. Slow down."
+	} else if wait := lastUse[useKey].Add(cooldown(cmdName)).Sub(now); wait > 0 {
+		notice = fmt.Sprintf("%s was used recently, try again in %d seconds.", cmdName, int(wait.Seconds())+1)
+	} else {
+		b.tokens--
+		lastUse[useKey] = now
+		return true, ""
+	}
+
+	//command refused, count it against the user
+	if now.Sub(b.lastStrike) > 10*time.Minute {
+		b.strikes = 0
+	}
+	if now.Sub(b.ignoredUntil) > time.Hour { //forgiven an hour after the last ignore ended
+		b.level = 0
+	}
+	b.strikes++
+	b.lastStrike = now
+	if b.strikes >= withDefault(config.RateLimit.Strikes, 3) {
+		ignore := time.Duration(withDefault(config.RateLimit.IgnoreSeconds, 60)) * time.Second
+		for i := uint(0); i < b.level && ignore < maxIgnore; i++ {
+			ignore *= 2
+		}
+		if ignore > maxIgnore {
+			ignore = maxIgnore
+		} else {
+			b.level++
+		}
+		b.ignoredUntil = now.Add(ignore)
+		b.strikes = 0
+		log.Printf("Ignoring %s!%s@%s for %v\n", nick, user, hostname, ignore)
+		b.lastNotice = now
+		return false, "Ignoring you for " + ignore.String() + "."
+	}
+	if now.Sub(b.lastNotice) < 30*time.Second {
+		return false, ""
+	}
+	b.lastNotice = now
+	return false, notice
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"errors"
+	"github.com/fzzy/radix/extra/pool"
+	"github.com/fzzy/radix/redis"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//redisStore keeps the bot's data in a redis server. Commands are spread over a pool of connections, since a single
+//connection can't be shared by the bot's goroutines and the web server's.
+type redisStore struct {
+	pool *pool.Pool
+	size int
+	stop chan bool //stops the loop checking idle connections
+}
+
+//openRedisStore connects to the redis server conf describes
+func openRedisStore(conf StoreConfig) (*redisStore, error) {
+	address := conf.Address
+	if address == "" {
+		address = "127.0.0.1:6379"
+	}
+	size := conf.PoolSize
+	if size <= 0 {
+		size = 10
+	}
+	timeout := time.Duration(conf.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	dial := func(network, addr string) (*redis.Client, error) {
+		client, err := redis.DialTimeout(network, addr, timeout)
+		if err != nil {
+			return nil, err
+		}
+		if conf.Password != "" {
+			if err := client.Cmd("auth", conf.Password).Err; err != nil {
+				client.Close()
+				return nil, err
+			}
+		}
+		if conf.DB != 0 {
+			if err := client.Cmd("select", conf.DB).Err; err != nil {
+				client.Close()
+				return nil, err
+			}
+		}
+		return client, nil
+	}
+	p, err := pool.NewCustomPool("tcp", address, size, dial)
+	if err != nil {
+		return nil, err
+	}
+	store := &redisStore{p, size, make(chan bool)}
+	go store.healthLoop()
+	return store, nil
+}
+
+//isConnErr reports whether err is a broken connection rather than an error reply from the server
+func isConnErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	_, isCmdErr := err.(*redis.CmdError)
+	return !isCmdErr
+}
+
+//readCommands are the commands cmd may safely run a second time. A command that fails with a broken connection may
+//still have reached the server, so retrying anything that changes data could apply it twice.
+var readCommands = map[string]bool{"get": true, "hget": true, "hgetall": true, "sismember": true, "smembers": true,
+	"scan": true, "zrangebyscore": true, "zrevrange": true}
+
+//cmd runs a command on a pooled connection, returning its reply and any error. If the connection turns out to be
+//broken (most likely because redis restarted) the other idle connections are dropped too, and read commands are tried
+//once more on a new one.
+func (store *redisStore) cmd(name string, args ...interface{}) (*redis.Reply, error) {
+	var reply *redis.Reply
+	for try := 0; try < 2; try++ {
+		client, err := store.pool.Get()
+		if err != nil {
+			return nil, err
+		}
+		reply = client.Cmd(name, args...)
+		store.pool.CarefullyPut(client, &reply.Err)
+		if !isConnErr(reply.Err) {
+			break
+		}
+		log.Println("Lost connection to redis, reconnecting:", reply.Err)
+		store.pool.Empty()
+		if !readCommands[name] {
+			break
+		}
+	}
+	return reply, reply.Err
+}
+
+//healthLoop pings the idle connections every half minute, closing any that are broken so commands don't have to find
+//out the hard way
+func (store *redisStore) healthLoop() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-store.stop:
+			return
+		case <-ticker.C:
+			clients := make([]*redis.Client, 0, store.size)
+			for i := 0; i < store.size; i++ {
+				client, err := store.pool.Get()
+				if err != nil {
+					log.Println("Can't reach redis:", err)
+					break
+				}
+				clients = append(clients, client)
+			}
+			for _, client := range clients {
+				err := client.Cmd("ping").Err
+				store.pool.CarefullyPut(client, &err)
+			}
+		}
+	}
+}
+
+//str returns a string reply, with found false for nil replies
+func str(reply *redis.Reply, err error) (string, bool, error) {
+	if err != nil {
+		return "", false, err
+	}
+	if reply.Type == redis.NilReply {
+		return "", false, nil
+	}
+	value, err := reply.Str()
+	return value, err == nil, err
+}
+
+func (store *redisStore) Get(key string) (string, bool, error) {
+	return str(store.cmd("get", key))
+}
+
+func (store *redisStore) Set(key, value string, ttl time.Duration) error {
+	if ttl > 0 {
+		_, err := store.cmd("setex", key, int(ttl/time.Second), value)
+		return err
+	}
+	_, err := store.cmd("set", key, value)
+	return err
+}
+
+func (store *redisStore) SetNX(key, value string) (bool, error) {
+	reply, err := store.cmd("setnx", key, value)
+	if err != nil {
+		return false, err
+	}
+	return reply.Bool()
+}
+
+func (store *redisStore) Del(keys ...string) error {
+	_, err := store.cmd("del", keys)
+	return err
+}
+
+func (store *redisStore) Expire(key string, ttl time.Duration) error {
+	_, err := store.cmd("expire", key, int(ttl/time.Second))
+	return err
+}
+
+func (store *redisStore) Incr(key string) (int64, error) {
+	reply, err := store.cmd("incr", key)
+	if err != nil {
+		return 0, err
+	}
+	return reply.Int64()
+}
+
+//globEscaper escapes the characters special to redis' glob-style patterns
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+//Keys uses SCAN rather than KEYS so the server isn't blocked while searching a large database
+func (store *redisStore) Keys(prefix string) ([]string, error) {
+	pattern := globEscaper.Replace(prefix) + "*"
+	var keys []string
+	seen := make(map[string]bool) //SCAN may return a key more than once
+	cursor := "0"
+	for {
+		reply, err := store.cmd("scan", cursor, "match", pattern, "count", 1000)
+		if err != nil {
+			return nil, err
+		}
+		if len(reply.Elems) != 2 {
+			return nil, errors.New("Unexpected reply to SCAN")
+		}
+		cursor, _ = reply.Elems[0].Str()
+		found, err := reply.Elems[1].List()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range found {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		if cursor == "0" {
+			return keys, nil
+		}
+	}
+}
+
+func (store *redisStore) Rename(key, newKey string) error {
+	_, err := store.cmd("rename", key, newKey)
+	return err
+}
+
+func (store *redisStore) HGet(key, field string) (string, bool, error) {
+	return str(store.cmd("hget", key, field))
+}
+
+func (store *redisStore) HSet(key, field, value string) error {
+	_, err := store.cmd("hset", key, field, value)
+	return err
+}
+
+func (store *redisStore) HDel(key string, fields ...string) error {
+	_, err := store.cmd("hdel", key, fields)
+	return err
+}
+
+func (store *redisStore) HGetAll(key string) (map[string]string, error) {
+	reply, err := store.cmd("hgetall", key)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Hash()
+}
+
+func (store *redisStore) HIncrBy(key, field string, n int64) (int64, error) {
+	reply, err := store.cmd("hincrby", key, field, n)
+	if err != nil {
+		return 0, err
+	}
+	return reply.Int64()
+}
+
+func (store *redisStore) SAdd(key string, members ...string) error {
+	_, err := store.cmd("sadd", key, members)
+	return err
+}
+
+func (store *redisStore) SRem(key string, members ...string) error {
+	_, err := store.cmd("srem", key, members)
+	return err
+}
+
+func (store *redisStore) SIsMember(key, member string) (bool, error) {
+	reply, err := store.cmd("sismember", key, member)
+	if err != nil {
+		return false, err
+	}
+	return reply.Bool()
+}
+
+func (store *redisStore) SMembers(key string) ([]string, error) {
+	reply, err := store.cmd("smembers", key)
+	if err != nil {
+		return nil, err
+	}
+	return reply.List()
+}
+
+func (store *redisStore) ZAdd(key string, score float64, member string) error {
+	_, err := store.cmd("zadd", key, score, member)
+	return err
+}
+
+func (store *redisStore) ZRangeByScore(key string, max float64) ([]string, error) {
+	reply, err := store.cmd("zrangebyscore", key, "-inf", "("+strconv.FormatFloat(max, 'f', -1, 64))
+	if err != nil {
+		return nil, err
+	}
+	return reply.List()
+}
+
+func (store *redisStore) ZRemRangeByScore(key string, max float64) error {
+	_, err := store.cmd("zremrangebyscore", key, "-inf", "("+strconv.FormatFloat(max, 'f', -1, 64))
+	return err
+}
+
+//ZInterRevRange intersects more than one key into a temporary key that expires after a minute, so repeating a query
+//(e.g. for the next page of results) doesn't redo the intersection
+func (store *redisStore) ZInterRevRange(keys []string, offset, count int) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	key := keys[0]
+	if len(keys) > 1 {
+		key = keyPrefix + "zinter:"
+		for _, k := range keys {
+			key += strconv.Itoa(len(k)) + ":" + k
+		}
+		args := []interface{}{key, len(keys)}
+		for _, k := range keys {
+			args = append(args, k)
+		}
+		args = append(args, "aggregate", "max")
+		if _, err := store.cmd("zinterstore", args...); err != nil {
+			return nil, err
+		}
+		store.cmd("expire", key, 60)
+	}
+	reply, err := store.cmd("zrevrange", key, offset, offset+count-1)
+	if err != nil {
+		return nil, err
+	}
+	return reply.List()
+}
+
+func (store *redisStore) Close() error {
+	close(store.stop)
+	store.pool.Empty()
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+//indexedMessage is a message stored in the search index
+type indexedMessage struct {
+	Time time.Time
+	Nick string
+	Text string
+}
+
+//searchPage is passed to search.html
+type searchPage struct {
+	Channel  string
+	Query    string
+	Results  []indexedMessage
+	Page     int
+	PrevPage int //0 if there's no previous page
+	NextPage int //0 if there's no next page
+}
+
+const searchPageSize = 25
+
+//searchKey returns the key holding part of channel's search index: "messages" (hash of id -> message), "times" (sorted
+//set of message ids, scored by time), "nextid" (counter of message ids) or "word:" + word (sorted set of ids of
+//messages containing word, scored by time)
+func searchKey(channel, suffix string) string {
+	return keyPrefix + "search:" + network() + ":" + ircLower(channel) + ":" + suffix
+}
+
+//searchTerms splits text into the lowercased words it is indexed and searched by
+func searchTerms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	seen := make(map[string]bool)
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		if len(word) < 2 || seen[word] {
+			continue
+		}
+		seen[word] = true
+		terms = append(terms, word)
+	}
+	return terms
+}
+
+//indexMessage adds a message nick sent to channel at t to the search index
+func indexMessage(channel, nick, text string, t time.Time) {
+	if !isChannel(channel) {
+		return
+	}
+	terms := searchTerms(text)
+	if len(terms) == 0 {
+		return
+	}
+	id, err := db.Incr(searchKey(channel, "nextid"))
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	msgBytes, err := json.Marshal(indexedMessage{t, nick, text})
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	idString := strconv.FormatInt(id, 10)
+	db.HSet(searchKey(channel, "messages"), idString, string(msgBytes))
+	db.ZAdd(searchKey(channel, "times"), float64(t.Unix()), idString)
+	for _, term := range terms {
+		db.ZAdd(searchKey(channel, "word:"+term), float64(t.Unix()), idString)
+	}
+}
+
+//pruneIndex removes the messages sent to channel before cutoff from the search index
+func pruneIndex(channel string, cutoff time.Time) error {
+	if err := indexTimes(channel); err != nil {
+		return err
+	}
+	keys, err := db.Keys(searchKey(channel, "word:"))
+	if err != nil {
+		return err
+	}
+	for _, key := range keys { //remove old ids from every word's set
+		if err := db.ZRemRangeByScore(key, float64(cutoff.Unix())); err != nil {
+			return err
+		}
+	}
+	ids, err := db.ZRangeByScore(searchKey(channel, "times"), float64(cutoff.Unix())) //and the messages themselves
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := db.HDel(searchKey(channel, "messages"), ids...); err != nil {
+		return err
+	}
+	return db.ZRemRangeByScore(searchKey(channel, "times"), float64(cutoff.Unix()))
+}
+
+//indexTimes fills in channel's "times" set from its messages if the index was built before there was one
+func indexTimes(channel string) error {
+	if keys, err := db.Keys(searchKey(channel, "times")); err != nil || len(keys) > 0 {
+		return err
+	}
+	messages, err := db.HGetAll(searchKey(channel, "messages"))
+	if err != nil {
+		return err
+	}
+	for id, msgJSON := range messages {
+		var msg indexedMessage
+		if err := json.Unmarshal([]byte(msgJSON), &msg); err != nil {
+			continue
+		}
+		if err := db.ZAdd(searchKey(channel, "times"), float64(msg.Time.Unix()), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//searchLog returns up to limit of the most recent messages in channel containing every word of query, skipping the
+//first offset matches. more is true if there are matches beyond those returned.
+func searchLog(channel, query string, offset, limit int) (results []indexedMessage, more bool, err error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil, false, errors.New("Nothing to search for")
+	}
+	keys := make([]string, len(terms))
+	for i, term := range terms {
+		keys[i] = searchKey(channel, "word:"+term)
+	}
+	ids, err := db.ZInterRevRange(keys, offset, limit+1) //one extra to see if there are more
+	if err != nil {
+		return nil, false, err
+	}
+	if len(ids) > limit {
+		ids, more = ids[:limit], true
+	}
+	for _, id := range ids {
+		msgJSON, found, err := db.HGet(searchKey(channel, "messages"), id)
+		if err != nil || !found {
+			continue
+		}
+		var msg indexedMessage
+		if err := json.Unmarshal([]byte(msgJSON), &msg); err == nil {
+			results = append(results, msg)
+		}
+	}
+	return results, more, nil
+}
+
+//searchHandler serves /logs/search/?channel=#channel&q=words&page=n
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	page := searchPage{Channel: r.FormValue("channel"), Query: r.FormValue("q"), Page: 1}
+	if n, err := strconv.Atoi(r.FormValue("page")); err == nil && n > 0 {
+		page.Page = n
+	}
+	if page.Channel != "" && !canView(r, page.Channel) {
+		http.Error(w, "This channel's logs are private", http.StatusForbidden)
+		return
+	}
+	var flashes []string
+	if page.Channel != "" && page.Query != "" {
+		results, more, err := searchLog(page.Channel, page.Query, (page.Page-1)*searchPageSize, searchPageSize)
+		if err != nil {
+			flashes = append(flashes, err.Error())
+		}
+		page.Results = results
+		if page.Page > 1 {
+			page.PrevPage = page.Page - 1
+		}
+		if more {
+			page.NextPage = page.Page + 1
+		}
+	}
+	render(w, r, "search.html", page, http.StatusOK, flashes...)
+}
+
+//formatResult formats a search result for IRC
+func formatResult(msg indexedMessage) string {
+	text := msg.Text
+	if runes := []rune(text); len(runes) > 120 {
+		text = string(runes[:117]) + "..."
+	}
+	return fmt.Sprintf("[%s] <%s> %s", msg.Time.Format("2006-01-02 15:04"), msg.Nick, text)
+}
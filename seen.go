@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+//seenRecord is the last thing a nick was seen doing
+type seenRecord struct {
+	logEvent
+	Channel string `json:"channel,omitempty"` //empty for quits and nick changes, which aren't sent to a channel
+}
+
+//seenKey returns the hash of lowercased nick -> seenRecord
+func seenKey() string {
+	return keyPrefix + "seen:" + network()
+}
+
+//seenOptOutKey returns the set of lowercased nicks that opted out of being tracked by seen
+func seenOptOutKey() string {
+	return keyPrefix + "seen:optout:" + network()
+}
+
+//seenOptedOut reports whether nick opted out of being tracked by seen
+func seenOptedOut(nick string) bool {
+	optedOut, err := db.SIsMember(seenOptOutKey(), ircLower(nick))
+	return err == nil && optedOut
+}
+
+//recordSeen records event as the last thing its nick was seen doing in channel. Events in unlogged channels and of
+//nicks that opted out aren't recorded. channel is empty for QUITs and NICKs, which are recorded if the nick shares a
+//logged channel with the bot.
+func recordSeen(channel string, event logEvent) {
+	if channel != "" && (!isChannel(channel) || channelConfig(channel).NoLog) {
+		return
+	}
+	if channel == "" {
+		logged := false
+		for _, shared := range channelsOf(event.Nick) {
+			logged = logged || !channelConfig(shared).NoLog
+		}
+		if !logged {
+			return
+		}
+	}
+	if seenOptedOut(event.Nick) {
+		return
+	}
+	recordBytes, err := json.Marshal(seenRecord{event, channel})
+	if err != nil {
+		return
+	}
+	db.HSet(seenKey(), ircLower(event.Nick), string(recordBytes))
+}
+
+//setSeenOptOut opts nick out of (or back in to) being tracked by seen, forgetting what it was last seen doing
+func setSeenOptOut(nick string, optOut bool) error {
+	if !optOut {
+		return db.SRem(seenOptOutKey(), ircLower(nick))
+	}
+	if err := db.SAdd(seenOptOutKey(), ircLower(nick)); err != nil {
+		return err
+	}
+	return db.HDel(seenKey(), ircLower(nick))
+}
+
+//lastSeen returns the records of nicks matching pattern (* and ? wildcards), most recent first
+func lastSeen(pattern string) ([]seenRecord, error) {
+	pattern = ircLower(pattern)
+	var records []seenRecord
+	if !strings.ContainsAny(pattern, "*?") {
+		recordJSON, found, err := db.HGet(seenKey(), pattern)
+		if err != nil {
+			return nil, err
+		}
+		var record seenRecord
+		if found && json.Unmarshal([]byte(recordJSON), &record) == nil {
+			records = append(records, record)
+		}
+		return records, nil
+	}
+	all, err := db.HGetAll(seenKey())
+	if err != nil {
+		return nil, err
+	}
+	for nick, recordJSON := range all {
+		var record seenRecord
+		if wildcardMatch(pattern, nick) && json.Unmarshal([]byte(recordJSON), &record) == nil {
+			records = append(records, record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Time.After(records[j].Time) })
+	return records, nil
+}
+
+//ago formats how long ago t was, like "3d 4h ago" or "5m ago"
+func ago(t time.Time) string {
+	d := time.Since(t)
+	days, hours, minutes := int(d.Hours())/24, int(d.Hours())%24, int(d.Minutes())%60
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh ago", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm ago", hours, minutes)
+	case minutes > 0:
+		return fmt.Sprintf("%dm ago", minutes)
+	}
+	return "just now"
+}
+
+//describeSeen formats record for a reply in channel. What was said in private channels is only repeated there.
+func describeSeen(record seenRecord, channel string) string {
+	where := record.Channel
+	hidden := record.Channel != "" && channelConfig(record.Channel).Private && !ircEqual(record.Channel, channel)
+	if hidden {
+		where = "a private channel"
+	}
+	message := record.Nick + " was last seen " + ago(record.Time) + " (" + record.Time.Format("2006-01-02 15:04 MST") +
+		") "
+	switch record.Type {
+	case "privmsg":
+		message += "in " + where
+		if !hidden {
+			message += " saying: " + record.Text
+		}
+	case "action":
+		message += "in " + where
+		if !hidden {
+			message += ": * " + record.Nick + " " + record.Text
+		}
+	case "join":
+		message += "joining " + where
+	case "part":
+		message += "leaving " + where
+		if record.Text != "" && !hidden {
+			message += " (" + record.Text + ")"
+		}
+	case "quit":
+		message += "quitting"
+		if record.Text != "" {
+			message += " (" + record.Text + ")"
+		}
+	case "nick":
+		message += "changing nick to " + record.Target
+	case "renamed":
+		message += "changing nick from " + record.Target
+	case "kick":
+		message += "kicking " + record.Target + " from " + where
+	case "kicked":
+		message += "being kicked from " + where + " by " + record.Target
+		if record.Text != "" && !hidden {
+			message += " (" + record.Text + ")"
+		}
+	}
+	if len(channelsOf(record.Nick)) > 0 && record.Type != "nick" && record.Type != "quit" {
+		message += ", and is still around"
+	}
+	return message
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	sessionCookie = "yaircb_session"
+	csrfCookie    = "yaircb_csrf" //holds the CSRF token of visitors who aren't logged in
+)
+
+//session is a logged in web user. Sessions are kept in the database, the browser only gets their ID.
+type session struct {
+	ID       string
+	User     string
+	CSRF     string //token every form posted in this session must include
+	Remember bool   //whether the cookie outlives the browser
+}
+
+//sessionKey returns the hash holding session id's user, csrf token and remember flag
+func sessionKey(id string) string {
+	return keyPrefix + "session:" + id
+}
+
+//randomToken returns n random bytes in hex
+func randomToken(n int) (string, error) {
+	tokenBytes := make([]byte, n)
+	if _, err := crand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(tokenBytes), nil
+}
+
+//sessionTTL returns how long a session lasts without being used
+func sessionTTL(remember bool) time.Duration {
+	if remember {
+		return time.Duration(withDefault(config.Web.RememberDays, 30)) * 24 * time.Hour
+	}
+	return time.Duration(withDefault(config.Web.SessionHours, 24)) * time.Hour
+}
+
+//cookieSameSite returns the SameSite mode set in config
+func cookieSameSite() http.SameSite {
+	switch strings.ToLower(config.Web.SameSite) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteLaxMode
+}
+
+//newCookie returns a cookie holding value with the attributes set in config, lasting maxAge or until the browser
+//closes if maxAge is 0. Cookies are only sent over HTTPS if the site is served over it, unless InsecureCookies is set.
+func newCookie(name, value string, maxAge time.Duration) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   config.Web.CookieDomain,
+		MaxAge:   int(maxAge / time.Second),
+		Secure:   strings.HasPrefix(baseURL(), "https://") && !config.Web.InsecureCookies,
+		HttpOnly: true,
+		SameSite: cookieSameSite(),
+	}
+}
+
+//setSessionCookie gives the browser the cookie for s
+func setSessionCookie(w http.ResponseWriter, s session) {
+	maxAge := time.Duration(0)
+	if s.Remember {
+		maxAge = sessionTTL(true)
+	}
+	http.SetCookie(w, newCookie(sessionCookie, s.ID, maxAge))
+}
+
+//getSession returns the session r belongs to, with found false if it has none or it has expired
+func getSession(r *http.Request) (s session, found bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || c.Value == "" {
+		return session{}, false
+	}
+	fields, err := db.HGetAll(sessionKey(c.Value))
+	if err != nil {
+		log.Println(err)
+		return session{}, false
+	}
+	if fields["user"] == "" {
+		return session{}, false
+	}
+	return session{c.Value, fields["user"], fields["csrf"], fields["remember"] == "1"}, true
+}
+
+//startSession logs r in as user, replacing any session it had
+func startSession(w http.ResponseWriter, r *http.Request, user string, remember bool) error {
+	if old, found := getSession(r); found {
+		deleteSession(old)
+	}
+	id, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+	csrf, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+	s := session{id, user, csrf, remember}
+	key := sessionKey(id)
+	rememberValue := ""
+	if remember {
+		rememberValue = "1"
+	}
+	//the key is set to expire before the user is stored, so it can't outlive an error part way through
+	if err := db.HSet(key, "csrf", csrf); err != nil {
+		return err
+	}
+	if err := db.Expire(key, sessionTTL(remember)); err != nil {
+		return err
+	}
+	if err := db.HSet(key, "remember", rememberValue); err != nil {
+		return err
+	}
+	if err := db.HSet(key, "user", user); err != nil {
+		return err
+	}
+	if err := db.SAdd(userKey(user, "sessions"), id); err != nil {
+		return err
+	}
+	setSessionCookie(w, s)
+	return nil
+}
+
+//deleteSession removes s from the database
+func deleteSession(s session) {
+	if err := db.Del(sessionKey(s.ID)); err != nil {
+		log.Println(err)
+	}
+	if err := db.SRem(userKey(s.User, "sessions"), s.ID); err != nil {
+		log.Println(err)
+	}
+}
+
+//endSession logs r out
+func endSession(w http.ResponseWriter, r *http.Request) {
+	if s, found := getSession(r); found {
+		deleteSession(s)
+	}
+	http.SetCookie(w, newCookie(sessionCookie, "", -time.Second))
+}
+
+//endUserSessions logs user name out everywhere
+func endUserSessions(name string) error {
+	ids, err := db.SMembers(userKey(name, "sessions"))
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := db.Del(sessionKey(id)); err != nil {
+			return err
+		}
+	}
+	return db.Del(userKey(name, "sessions"))
+}
+
+//pruneSessions removes sessions that have expired from their users' sets of sessions
+func pruneSessions() {
+	keys, err := db.Keys(keyPrefix + "user:")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, key := range keys {
+		if !strings.HasSuffix(key, ":sessions") {
+			continue
+		}
+		ids, err := db.SMembers(key)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		for _, id := range ids {
+			if _, found, err := db.HGet(sessionKey(id), "user"); err != nil {
+				log.Println(err)
+			} else if !found {
+				if err := db.SRem(key, id); err != nil {
+					log.Println(err)
+				}
+			}
+		}
+	}
+}
+
+//webUser returns the name of the user logged in with r's session, or "" if nobody is
+func webUser(r *http.Request) string {
+	s, _ := getSession(r)
+	return s.User
+}
+
+//renewSessions wraps handler so every request made in a session pushes back the session's expiry
+func renewSessions(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s, found := getSession(r); found {
+			if err := db.Expire(sessionKey(s.ID), sessionTTL(s.Remember)); err != nil {
+				log.Println(err)
+			} else if s.Remember {
+				setSessionCookie(w, s)
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+//csrfToken returns the token forms rendered for r must include. Visitors who aren't logged in are given one in a
+//cookie, which the form has to repeat.
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if s, found := getSession(r); found {
+		return s.CSRF
+	}
+	if c, err := r.Cookie(csrfCookie); err == nil && c.Value != "" {
+		return c.Value
+	}
+	token, err := randomToken(32)
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+	http.SetCookie(w, newCookie(csrfCookie, token, 0))
+	return token
+}
+
+//checkCSRF reports whether r is a POST carrying the CSRF token of the session or visitor it came from
+func checkCSRF(r *http.Request) bool {
+	if r.Method != "POST" {
+		return false
+	}
+	want := ""
+	if s, found := getSession(r); found {
+		want = s.CSRF
+	} else if c, err := r.Cookie(csrfCookie); err == nil {
+		want = c.Value
+	}
+	got := r.PostFormValue("csrf")
+	return want != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
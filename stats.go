@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//statsWindows lists the time windows accepted by wc and top
+var statsWindows = []string{"today", "yesterday", "week", "month", "all"}
+
+//statsKey returns the hash of nick -> message count for channel on day (formatted 2006-01-02), or for all time
+//if day is "all"
+func statsKey(channel, day string) string {
+	return keyPrefix + "stats:" + network() + ":" + ircLower(channel) + ":" + day
+}
+
+//importedPrefix starts the fields of stats hashes holding counts imported from old logs. They're kept apart from the
+//counts of messages the bot saw itself so importing again can replace them, and are added to them when read.
+const importedPrefix = "imported:"
+
+//countMessage records that nick sent a message to channel at t
+func countMessage(channel, nick string, t time.Time) {
+	if !isChannel(channel) {
+		return
+	}
+	nick = ircLower(nick)
+	db.HIncrBy(statsKey(channel, "all"), nick, 1)
+	db.HIncrBy(statsKey(channel, t.Format("2006-01-02")), nick, 1)
+}
+
+//windowDays returns the days covered by window, or nil for all time
+func windowDays(window string) ([]string, error) {
+	now := time.Now()
+	days := 0
+	switch strings.ToLower(window) {
+	case "", "all":
+		return nil, nil
+	case "today":
+		return []string{now.Format("2006-01-02")}, nil
+	case "yesterday":
+		return []string{now.AddDate(0, 0, -1).Format("2006-01-02")}, nil
+	case "week":
+		days = 7
+	case "month":
+		days = 30
+	default:
+		return nil, errors.New("Unknown time window '" + window + "', try one of " + strings.Join(statsWindows, ", "))
+	}
+	dayList := make([]string, days)
+	for i := range dayList {
+		dayList[i] = now.AddDate(0, 0, -i).Format("2006-01-02")
+	}
+	return dayList, nil
+}
+
+//nickCount returns the number of messages the person nick belongs to has sent to channel within window
+func nickCount(channel, nick, window string) (int, error) {
+	days, err := windowDays(window)
+	if err != nil {
+		return 0, err
+	}
+	if days == nil {
+		days = []string{"all"}
+	}
+	total := 0
+	for _, day := range days {
+		for _, alias := range personNicks(nick) {
+			for _, field := range []string{alias, importedPrefix + alias} {
+				count, found, err := db.HGet(statsKey(channel, day), field)
+				if err != nil {
+					return 0, err
+				}
+				if n, err := strconv.Atoi(count); found && err == nil {
+					total += n
+				}
+			}
+		}
+	}
+	return total, nil
+}
+
+//channelCounts returns the number of messages sent to channel within window by every person
+func channelCounts(channel, window string) (map[string]int, error) {
+	days, err := windowDays(window)
+	if err != nil {
+		return nil, err
+	}
+	if days == nil {
+		days = []string{"all"}
+	}
+	counts := make(map[string]int)
+	aliases := aliasMap()
+	for _, day := range days {
+		hash, err := db.HGetAll(statsKey(channel, day))
+		if err != nil {
+			return nil, err
+		}
+		for nick, count := range hash {
+			n, err := strconv.Atoi(count)
+			if err != nil {
+				continue
+			}
+			nick = strings.TrimPrefix(nick, importedPrefix)
+			if person, merged := aliases[nick]; merged {
+				nick = person
+			}
+			counts[nick] += n
+		}
+	}
+	return counts, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+//Store is the bot's database. Its operations mirror the redis commands the bot was first written against, so every
+//backend holds the same keys with the same kinds of values: strings, hashes, sets and sorted sets.
+type Store interface {
+	//Get returns the string at key, with found false if there's none
+	Get(key string) (value string, found bool, err error)
+	//Set stores value at key, expiring after ttl unless ttl is 0
+	Set(key, value string, ttl time.Duration) error
+	//SetNX stores value at key unless key already holds a value, reporting whether it did
+	SetNX(key, value string) (bool, error)
+	//Del removes keys of any kind
+	Del(keys ...string) error
+	//Expire makes key expire after ttl
+	Expire(key string, ttl time.Duration) error
+	//Incr adds one to the integer at key and returns the result
+	Incr(key string) (int64, error)
+	//Keys returns every key starting with prefix
+	Keys(prefix string) ([]string, error)
+	//Rename moves the value at key, of any kind, to newKey, replacing whatever was there
+	Rename(key, newKey string) error
+
+	HGet(key, field string) (value string, found bool, err error)
+	HSet(key, field, value string) error
+	HDel(key string, fields ...string) error
+	HGetAll(key string) (map[string]string, error)
+	HIncrBy(key, field string, n int64) (int64, error)
+
+	SAdd(key string, members ...string) error
+	SRem(key string, members ...string) error
+	SIsMember(key, member string) (bool, error)
+	SMembers(key string) ([]string, error)
+
+	ZAdd(key string, score float64, member string) error
+	//ZRangeByScore returns the members of key scored below max, lowest scored first
+	ZRangeByScore(key string, max float64) ([]string, error)
+	//ZRemRangeByScore removes the members of key scored below max
+	ZRemRangeByScore(key string, max float64) error
+	//ZInterRevRange returns up to count members present in every one of keys, highest scored (by their highest score)
+	//first, skipping the first offset
+	ZInterRevRange(keys []string, offset, count int) ([]string, error)
+
+	Close() error
+}
+
+//StoreConfig selects and configures the Store backend
+type StoreConfig struct {
+	Backend  string //"redis" (default), "memory" (nothing is kept across restarts) or "file"
+	Address  string //redis server, "127.0.0.1:6379" if empty
+	Password string //redis password, if the server requires one
+	DB       int    //redis database index
+	PoolSize int    //redis connections kept open (10)
+	Timeout  int    //seconds to wait connecting to redis or for a reply before giving up (5)
+	Path     string //file backend's database, "yaircb.db" if empty
+}
+
+//keyPrefix starts every key the bot stores, so it can share a database with other programs
+const keyPrefix = "yaircb:"
+
+//schemaKey holds the version of the key schema the database uses, schemaVersion for databases created or migrated by
+//this version of the bot
+const (
+	schemaKey     = keyPrefix + "schema"
+	schemaVersion = "2"
+)
+
+var db Store //opened in main
+
+//openStore opens the backend described by conf
+func openStore(conf StoreConfig) (Store, error) {
+	switch conf.Backend {
+	case "", "redis":
+		return openRedisStore(conf)
+	case "memory":
+		return newMemoryStore(), nil
+	case "file":
+		path := conf.Path
+		if path == "" {
+			path = "yaircb.db"
+		}
+		return openFileStore(path)
+	}
+	return nil, errors.New("Unknown store backend '" + conf.Backend + "', try redis, memory or file")
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+//testBackends returns a function opening an empty store for every backend that doesn't need a server
+func testBackends(t *testing.T) map[string]func() Store {
+	return map[string]func() Store{
+		"memory": func() Store { return newMemoryStore() },
+		"file": func() Store {
+			store, err := openFileStore(filepath.Join(t.TempDir(), "yaircb.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		},
+	}
+}
+
+//sorted returns list sorted, or the error in its place so comparing it fails
+func sorted(list []string, err error) []string {
+	if err != nil {
+		return []string{err.Error()}
+	}
+	sort.Strings(list)
+	return list
+}
+
+var storeTests = []struct {
+	name string
+	run  func(t *testing.T, store Store)
+}{
+	{"strings", func(t *testing.T, store Store) {
+		if _, found, err := store.Get("missing"); found || err != nil {
+			t.Fatalf("Get(missing) found %v, err %v", found, err)
+		}
+		if err := store.Set("a", "1", 0); err != nil {
+			t.Fatal(err)
+		}
+		if value, found, _ := store.Get("a"); !found || value != "1" {
+			t.Fatalf("Get(a) = %q, %v, want 1", value, found)
+		}
+		if set, _ := store.SetNX("a", "2"); set {
+			t.Fatal("SetNX replaced an existing value")
+		}
+		if set, _ := store.SetNX("b", "2"); !set {
+			t.Fatal("SetNX didn't set a new key")
+		}
+		if n, err := store.Incr("count"); n != 1 || err != nil {
+			t.Fatalf("Incr = %d, %v, want 1", n, err)
+		}
+		if n, _ := store.Incr("count"); n != 2 {
+			t.Fatalf("Incr = %d, want 2", n)
+		}
+		if err := store.Del("a", "b"); err != nil {
+			t.Fatal(err)
+		}
+		if _, found, _ := store.Get("a"); found {
+			t.Fatal("Del didn't remove a")
+		}
+	}},
+	{"expiry", func(t *testing.T, store Store) {
+		store.Set("short", "1", 20*time.Millisecond)
+		store.Set("long", "1", time.Hour)
+		store.HSet("hash", "field", "1")
+		store.Expire("hash", 20*time.Millisecond)
+		time.Sleep(40 * time.Millisecond)
+		if _, found, _ := store.Get("short"); found {
+			t.Fatal("string outlived its ttl")
+		}
+		if _, found, _ := store.Get("long"); !found {
+			t.Fatal("string expired early")
+		}
+		if hash, _ := store.HGetAll("hash"); len(hash) != 0 {
+			t.Fatal("hash outlived its ttl")
+		}
+	}},
+	{"keys and rename", func(t *testing.T, store Store) {
+		store.Set("x:1", "1", 0)
+		store.HSet("x:2", "f", "v")
+		store.SAdd("y:1", "m")
+		if keys := sorted(store.Keys("x:")); !reflect.DeepEqual(keys, []string{"x:1", "x:2"}) {
+			t.Fatalf("Keys(x:) = %v", keys)
+		}
+		if err := store.Rename("x:2", "y:2"); err != nil {
+			t.Fatal(err)
+		}
+		if value, _, _ := store.HGet("y:2", "f"); value != "v" {
+			t.Fatalf("renamed hash holds %q, want v", value)
+		}
+		if keys := sorted(store.Keys("")); !reflect.DeepEqual(keys, []string{"x:1", "y:1", "y:2"}) {
+			t.Fatalf("Keys() = %v", keys)
+		}
+	}},
+	{"hashes", func(t *testing.T, store Store) {
+		store.HSet("h", "a", "1")
+		store.HSet("h", "b", "2")
+		if n, err := store.HIncrBy("h", "a", 5); n != 6 || err != nil {
+			t.Fatalf("HIncrBy = %d, %v, want 6", n, err)
+		}
+		if n, _ := store.HIncrBy("h", "c", -1); n != -1 {
+			t.Fatalf("HIncrBy of a new field = %d, want -1", n)
+		}
+		store.HDel("h", "b")
+		hash, err := store.HGetAll("h")
+		if err != nil || !reflect.DeepEqual(hash, map[string]string{"a": "6", "c": "-1"}) {
+			t.Fatalf("HGetAll = %v, %v", hash, err)
+		}
+		if _, found, _ := store.HGet("h", "b"); found {
+			t.Fatal("HDel didn't remove b")
+		}
+	}},
+	{"sets", func(t *testing.T, store Store) {
+		store.SAdd("s", "a", "b", "c")
+		store.SRem("s", "b")
+		if members := sorted(store.SMembers("s")); !reflect.DeepEqual(members, []string{"a", "c"}) {
+			t.Fatalf("SMembers = %v", members)
+		}
+		if member, _ := store.SIsMember("s", "b"); member {
+			t.Fatal("SRem didn't remove b")
+		}
+		if member, _ := store.SIsMember("s", "a"); !member {
+			t.Fatal("a isn't a member")
+		}
+	}},
+	{"sorted sets", func(t *testing.T, store Store) {
+		store.ZAdd("z1", 1, "a")
+		store.ZAdd("z1", 2, "b")
+		store.ZAdd("z1", 3, "c")
+		store.ZAdd("z2", 2, "b")
+		store.ZAdd("z2", 3, "c")
+		store.ZAdd("z2", 4, "d")
+		if members, _ := store.ZRangeByScore("z1", 3); !reflect.DeepEqual(members, []string{"a", "b"}) {
+			t.Fatalf("ZRangeByScore = %v", members)
+		}
+		if members, _ := store.ZInterRevRange([]string{"z1", "z2"}, 0, 10); !reflect.DeepEqual(members, []string{"c", "b"}) {
+			t.Fatalf("ZInterRevRange = %v", members)
+		}
+		if members, _ := store.ZInterRevRange([]string{"z2"}, 1, 1); !reflect.DeepEqual(members, []string{"c"}) {
+			t.Fatalf("ZInterRevRange with offset = %v", members)
+		}
+		store.ZRemRangeByScore("z1", 3)
+		if members, _ := store.ZInterRevRange([]string{"z1"}, 0, 10); !reflect.DeepEqual(members, []string{"c"}) {
+			t.Fatalf("after ZRemRangeByScore z1 = %v", members)
+		}
+	}},
+	{"wrong type", func(t *testing.T, store Store) {
+		store.Set("str", "1", 0)
+		if _, err := store.HGetAll("str"); err == nil {
+			t.Fatal("HGetAll of a string succeeded")
+		}
+		if err := store.SAdd("str", "a"); err == nil {
+			t.Fatal("SAdd to a string succeeded")
+		}
+	}},
+}
+
+func TestStores(t *testing.T) {
+	for backend, open := range testBackends(t) {
+		for _, test := range storeTests {
+			t.Run(backend+"/"+test.name, func(t *testing.T) {
+				store := open()
+				defer store.Close()
+				test.run(t, store)
+			})
+		}
+	}
+}
+
+func TestFileStoreReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "yaircb.db")
+	store, err := openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Set("kept", "1", 0)
+	store.Set("gone", "1", 20*time.Millisecond)
+	store.HSet("h", "f", "v")
+	store.SAdd("s", "a")
+	store.ZAdd("z", 1.5, "a")
+	store.Rename("h", "h2")
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(40 * time.Millisecond)
+
+	store, err = openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if value, _, _ := store.Get("kept"); value != "1" {
+		t.Fatalf("kept = %q after reopening", value)
+	}
+	if _, found, _ := store.Get("gone"); found {
+		t.Fatal("expired key came back after reopening")
+	}
+	if value, _, _ := store.HGet("h2", "f"); value != "v" {
+		t.Fatalf("h2.f = %q after reopening", value)
+	}
+	if member, _ := store.SIsMember("s", "a"); !member {
+		t.Fatal("set member lost after reopening")
+	}
+	if members, _ := store.ZRangeByScore("z", 2); !reflect.DeepEqual(members, []string{"a"}) {
+		t.Fatalf("z = %v after reopening", members)
+	}
+}
+
+func TestMemoryStoreSweeps(t *testing.T) {
+	store := newMemoryStore()
+	store.Set("gone", "1", 20*time.Millisecond)
+	store.HSet("hash", "f", "v")
+	store.Expire("hash", 20*time.Millisecond)
+	store.Set("kept", "1", 0)
+	time.Sleep(40 * time.Millisecond)
+	store.swept = time.Now().Add(-sweepInterval)
+	store.Get("kept") //removes the expired keys without reading them
+	if _, found := store.strings["gone"]; found {
+		t.Fatal("expired string wasn't swept")
+	}
+	if store.hashes["hash"] != nil || len(store.expires) != 0 {
+		t.Fatal("expired hash wasn't swept")
+	}
+}
+
+func TestFileStoreCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "yaircb.db")
+	store, err := openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < minCompactOps; i++ {
+		store.Set("counter", "value", 0)
+	}
+	store.mutex.Lock()
+	store.writer.Flush()
+	grown, _ := os.Stat(path)
+	store.recompact()
+	store.mutex.Unlock()
+	compacted, _ := os.Stat(path)
+	if compacted.Size() >= grown.Size()/100 {
+		t.Fatalf("journal is %d bytes after compacting, %d before", compacted.Size(), grown.Size())
+	}
+	store.Set("after", "1", 0) //written to the new journal
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	store, err = openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for _, key := range []string{"counter", "after"} {
+		if _, found, _ := store.Get(key); !found {
+			t.Fatalf("%s lost by compacting", key)
+		}
+	}
+}
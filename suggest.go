@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	suggestMutex sync.Mutex
+	lastSuggest  = make(map[string]time.Time) //lowercased channel -> time of the last suggestion made there
+)
+
+//editDistance returns the Damerau-Levenshtein (optimal string alignment) distance between a and b
+func editDistance(a, b string) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, minInt(cur[j-1]+1, prev[j-1]+cost))
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = minInt(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+//suggestCommand returns the command usable in channel that is closest to cmdName, or "" if none is close enough to be a
+//likely typo
+func suggestCommand(channel, cmdName string) string {
+	names := make([]string, 0, len(funcMap))
+	for name := range funcMap {
+		names = append(names, name)
+	}
+	sort.Strings(names) //break ties alphabetically
+
+	best, bestDistance := "", len(cmdName)/4+2 //allow one edit, plus one for every four characters
+	for _, name := range names {
+		if !commandEnabled(channel, name) {
+			continue
+		}
+		if distance := editDistance(cmdName, name); distance < bestDistance {
+			best, bestDistance = name, distance
+		}
+	}
+	return best
+}
+
+//suggest replies in channel with the closest match to the unknown command cmdName. Suggestions are made at most once
+//every 30 seconds per channel and never in quiet channels.
+func suggest(srvChan chan string, channel, cmdName string) {
+	if channelConfig(channel).Quiet {
+		return
+	}
+	suggestion := suggestCommand(channel, cmdName)
+	if suggestion == "" {
+		return
+	}
+	suggestMutex.Lock()
+	key := ircLower(channel)
+	if time.Since(lastSuggest[key]) < 30*time.Second {
+		suggestMutex.Unlock()
+		return
+	}
+	lastSuggest[key] = time.Now()
+	suggestMutex.Unlock()
+
+	message := replyPrefix(channel) + "Unknown command '" + cmdName + "'. Did you mean '" + suggestion + "'?"
+	srvChan <- message
+	log.Println(message)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//userKey returns the key holding part of user name's account: "password" for their password hash, "pin" for the PIN
+//used to verify IRC identities, "pinFailures" for the count of wrong PINs given recently, "identities" for the hash of
+//identities verified and "sessions" for the set of their session IDs
+func userKey(name, part string) string {
+	return keyPrefix + "user:" + name + ":" + part
+}
+
+//hashPassword returns the bcrypt hash of password, at the cost set in config
+func hashPassword(password string) (string, error) {
+	cost := config.Web.BcryptCost
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	return string(hash), err
+}
+
+//checkPassword reports whether password is user name's password, false if there's no such user. Hashes from before
+//passwords were hashed with bcrypt (unsalted hex SHA-512), or with a different cost than config sets, are replaced
+//once the password is known to be right.
+func checkPassword(name, password string) (bool, error) {
+	hash, found, err := db.Get(userKey(name, "password"))
+	if err != nil || !found {
+		return false, err
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	legacy := err != nil
+	if legacy {
+		sum := sha512.Sum512([]byte(password))
+		if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(hash)) != 1 {
+			return false, nil
+		}
+	} else if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false, nil
+	}
+	if wantCost := config.Web.BcryptCost; legacy || (wantCost != 0 && wantCost != cost) {
+		if hash, err := hashPassword(password); err != nil {
+			log.Println("Can't rehash password of", name+":", err)
+		} else if err := db.Set(userKey(name, "password"), hash, 0); err != nil {
+			log.Println("Can't rehash password of", name+":", err)
+		}
+	}
+	return true, nil
+}
+
+//createUser stores a new user with password and a fresh PIN, with created false if the name is already taken
+func createUser(name, password string) (created bool, err error) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return false, err
+	}
+	if created, err := db.SetNX(userKey(name, "password"), hash); err != nil || !created {
+		return false, err
+	}
+	_, _, err = newUserPin(name)
+	return true, err
+}
+
+//pinTTL returns how long a PIN can be used for
+func pinTTL() time.Duration {
+	return time.Duration(withDefault(config.Web.PinMinutes, 15)) * time.Minute
+}
+
+//userPin returns the PIN of user name and when it expires, with pin "" if they don't have one or it's expired. PINs
+//are stored with their expiry time in seconds, so PINs from before they expired are ignored.
+func userPin(name string) (pin string, expires time.Time, err error) {
+	value, found, err := db.Get(userKey(name, "pin"))
+	if err != nil || !found {
+		return "", time.Time{}, err
+	}
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return "", time.Time{}, nil
+	}
+	seconds, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}, nil
+	}
+	expires = time.Unix(seconds, 0)
+	if time.Now().After(expires) {
+		return "", time.Time{}, nil
+	}
+	return fields[0], expires, nil
+}
+
+//newUserPin gives user name a new random PIN, returning it and when it expires
+func newUserPin(name string) (pin string, expires time.Time, err error) {
+	n, err := crand.Int(crand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	pin = fmt.Sprintf("%06d", n.Int64())
+	expires = time.Now().Add(pinTTL()).Truncate(time.Second)
+	err = db.Set(userKey(name, "pin"), pin+" "+strconv.FormatInt(expires.Unix(), 10), pinTTL())
+	return pin, expires, err
+}
+
+//pinLocked reports whether user name has given so many wrong PINs that verify won't check any more for now
+func pinLocked(name string) (bool, error) {
+	failures, found, err := db.Get(userKey(name, "pinFailures"))
+	if err != nil || !found {
+		return false, err
+	}
+	count, _ := strconv.Atoi(failures)
+	return count >= withDefault(config.Web.PinAttempts, 5), nil
+}
+
+//clearPinFailures forgets the wrong PINs given for user name
+func clearPinFailures(name string) error {
+	return db.Del(userKey(name, "pinFailures"))
+}
+
+//recordPinFailure counts a wrong PIN given for user name. Once they're locked out their PIN is deleted, so guessing
+//has to start again against a new one.
+func recordPinFailure(name string) error {
+	key := userKey(name, "pinFailures")
+	count, err := db.Incr(key)
+	if err != nil {
+		return err
+	}
+	if count == 1 {
+		if err := db.Expire(key, time.Duration(withDefault(config.Web.PinLockoutMinutes, 15))*time.Minute); err != nil {
+			return err
+		}
+	}
+	if count >= int64(withDefault(config.Web.PinAttempts, 5)) {
+		log.Println("Too many wrong PINs for", name+", locking verification")
+		return db.Del(userKey(name, "pin"))
+	}
+	return nil
+}
+
+//deleteUser removes user name's account, logging them out everywhere and undoing the nick links verifying their
+//identities made
+func deleteUser(name string) error {
+	identities, err := userIdentities(name)
+	if err != nil {
+		return err
+	}
+	for _, id := range identities {
+		if id.Linked && id.Network == network() {
+			if _, err := unlinkNick(id.Nick); err != nil {
+				return err
+			}
+		}
+	}
+	if err := endUserSessions(name); err != nil {
+		return err
+	}
+	for _, part := range []string{"identities", "pin", "pinFailures"} {
+		if err := db.Del(userKey(name, part)); err != nil {
+			return err
+		}
+	}
+	//the password goes last, so the name can't be registered again while anything of the account is left. Sessions
+	//are ended again once nobody can log in, in case someone did while the rest was deleted.
+	if err := db.Del(userKey(name, "password")); err != nil {
+		return err
+	}
+	return endUserSessions(name)
+}
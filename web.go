@@ -1,174 +1,186 @@
 package main
 
 import (
-	crand "crypto/rand"
-	"crypto/sha512"
-	"encoding/hex"
-	"fmt"
-	"github.com/fzzy/radix/redis"
-	"html/template"
-	"math/rand"
+	"crypto/tls"
+	"log"
+	"net"
 	"net/http"
-	"os"
+	"strings"
 	"time"
 )
 
-var webDb *redis.Client
+//WebConfig configures the web server
+type WebConfig struct {
+	Listen               string //address to listen on (":8080")
+	CertFile             string //TLS certificate ("ssl.crt")
+	KeyFile              string //TLS key ("ssl.pem")
+	PlainHTTP            bool   //serve plain HTTP, e.g. behind a reverse proxy that handles TLS
+	TrustProxy           bool   //take client addresses from the X-Forwarded-For header set by a reverse proxy
+	BaseURL              string //public address of the site used in links, e.g. "https://example.com"
+	BcryptCost           int    //bcrypt cost of password hashes (10), existing hashes are upgraded as their users log in
+	MinPasswordLength    int    //shortest password that may be registered (8)
+	RegistrationsPerHour int    //accounts one IP address may register in an hour (3)
+	PinMinutes           int    //minutes a verification PIN lasts (15)
+	PinAttempts          int    //wrong PINs after which verification is locked (5)
+	PinLockoutMinutes    int    //minutes wrong PINs are counted for, and verification stays locked (15)
+	SessionHours         int    //hours a session lasts without being used (24)
+	RememberDays         int    //days a session lasts without being used when "Remember Me" is ticked (30)
+	CookieDomain         string //domain cookies are set for, the host the site was reached at if empty
+	InsecureCookies      bool   //send cookies over plain HTTP too, even if BaseURL is https, for testing without TLS
+	SameSite             string //SameSite mode of cookies: "lax" (default), "strict" or "none"
+	TemplateDir          string //directory to read templates and resources from instead of those built in, for development
+}
 
-type User struct {
-	Uname  string
-	Pwd    string
-	Cookie bool
-	Pin    string
+//listenAddress returns the address the web server listens on
+func listenAddress() string {
+	if config.Web.Listen == "" {
+		return ":8080"
+	}
+	return config.Web.Listen
+}
+
+//baseURL returns the public address of the site, without a trailing slash. If it isn't configured it's guessed from
+//the listen address, which only works from the same machine.
+func baseURL() string {
+	if config.Web.BaseURL != "" {
+		return strings.TrimRight(config.Web.BaseURL, "/")
+	}
+	scheme := "https"
+	if config.Web.PlainHTTP {
+		scheme = "http"
+	}
+	_, port, _ := net.SplitHostPort(listenAddress())
+	return scheme + "://localhost:" + port
 }
 
-func initWebRedis() {
-	var err error
-	webDb, err = redis.Dial("tcp", "127.0.0.1:6379")
+//webURL returns the public URL of path, which starts with "/"
+func webURL(path string) string {
+	return baseURL() + path
+}
+
+//startWebServer starts serving handler, returning an error if it can't listen or load its certificate
+func startWebServer(handler http.Handler) error {
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	if !config.Web.PlainHTTP {
+		certFile, keyFile := config.Web.CertFile, config.Web.KeyFile
+		if certFile == "" {
+			certFile = "ssl.crt"
+		}
+		if keyFile == "" {
+			keyFile = "ssl.pem"
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	listener, err := net.Listen("tcp", listenAddress())
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return err
+	}
+	if config.Web.BaseURL == "" {
+		log.Println("Web.BaseURL isn't set, links to the web server will use", baseURL())
 	}
+	go func() {
+		var err error
+		if config.Web.PlainHTTP {
+			err = server.Serve(listener)
+		} else {
+			err = server.ServeTLS(listener, "", "")
+		}
+		log.Fatal("Web server stopped: ", err)
+	}()
+	return nil
 }
 
-func indexHandler(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "index.html")
+//User is what user.html is rendered with
+type User struct {
+	Uname      string
+	Pin        string
+	PinExpires time.Time
+	Identities []identity
 }
 
-func registerHandler(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "register.html")
+//loginPage is what login.html is rendered with
+type loginPage struct {
+	Username string
+}
+
+//formExpired is shown when a form is posted without the right CSRF token, usually because it was loaded before the
+//session it was loaded in ended
+const formExpired = "Your form expired, please try again."
+
+func indexHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	render(w, r, "index.html", nil, http.StatusOK)
 }
 
 func loginHandler(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "login.html")
+	render(w, r, "login.html", loginPage{}, http.StatusOK)
 }
 
 func loginCheckHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkCSRF(r) {
+		render(w, r, "login.html", loginPage{}, http.StatusForbidden, formExpired)
+		return
+	}
 	uname := r.FormValue("username")
-	remember := false
-	if r.FormValue("remember") == "on" {
-		remember = true
-	}
-	pwdBytes := sha512.Sum512([]byte(r.FormValue("pwd")))
-	pwd := hex.EncodeToString(pwdBytes[:])
-	fmt.Println("Form Values:", r.PostForm)
-	uReply := webDb.Cmd("get", uname)
-	uFound, err := uReply.Bool()
+	remember := r.FormValue("remember") == "on"
+	pwdMatch, err := checkPassword(uname, r.FormValue("pwd"))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if uFound {
-		fmt.Println("user found")
-		pwdDb, err := uReply.Bytes()
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		if pwd == string(pwdDb) {
-			if remember {
-				c := makeCookie(uname)
-				http.SetCookie(w, &c)
-			}
-			fmt.Println("password match")
-			t, err := template.ParseFiles("user.html")
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			pinReply := webDb.Cmd("get", uname+"Pin")
-			pin, err := pinReply.Bytes()
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			u := User{uname, pwd, remember, string(pin)}
-			t.Execute(w, u)
-		} else {
-			http.Redirect(w, r, "/login/", http.StatusFound)
-		}
-	} else {
-		http.Redirect(w, r, "/login/", http.StatusFound)
+	if !pwdMatch {
+		render(w, r, "login.html", loginPage{uname}, http.StatusUnauthorized, "Wrong username or password.")
+		return
 	}
+	if err := startSession(w, r, uname, remember); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Println("Web user", uname, "logged in from", clientIP(r))
+	http.Redirect(w, r, "/user/", http.StatusSeeOther)
 }
 
-func saveHandler(w http.ResponseWriter, r *http.Request) {
-	uname := r.FormValue("username")
-	pwdBytes := sha512.Sum512([]byte(r.FormValue("pwd")))
-	pwd := hex.EncodeToString(pwdBytes[:])
-	fmt.Println("Form Values:", r.PostForm)
-	pinStr := fmt.Sprintf("%06d", rand.Intn(1000000))
-	webDb.Cmd("set", uname, pwd)
-	webDb.Cmd("set", uname+"Pin", pinStr)
-	fmt.Println(pinStr)
-	userCookie := makeCookie(uname)
-	http.SetCookie(w, &userCookie)
-	http.Redirect(w, r, "/user/"+uname, http.StatusFound)
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkCSRF(r) {
+		http.Redirect(w, r, "/user/", http.StatusSeeOther)
+		return
+	}
+	endSession(w, r)
+	setFlash(w, "You've logged out.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+//userHandler shows the page of the logged in user. /user/<name> is accepted for old links, but only shows the logged
+//in user's own page.
 func userHandler(w http.ResponseWriter, r *http.Request) {
-	u := User{}
-	u.Uname = r.URL.Path[len("/user/"):]
-	reply := webDb.Cmd("get", u.Uname)
-	pwd, err := reply.Bytes()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	uname := webUser(r)
+	if uname == "" {
+		http.Redirect(w, r, "/login/", http.StatusFound)
 		return
 	}
-	u.Pwd = string(pwd)
-	reply = webDb.Cmd("get", u.Uname+"Pin")
-	pin, err := reply.Bytes()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if path := r.URL.Path[len("/user/"):]; path != "" && path != uname {
+		http.Redirect(w, r, "/user/", http.StatusFound)
 		return
 	}
-	u.Pin = string(pin)
-	u.Cookie = false
-	t, err := template.ParseFiles("user.html")
-	if err != nil {
-		fmt.Println(err)
+	pin, expires, err := userPin(uname)
+	if err == nil && pin == "" {
+		pin, expires, err = newUserPin(uname)
 	}
-	fmt.Println("Username:", u.Uname)
-	fmt.Println("Password:", u.Pwd)
-	fmt.Println("Pin:", u.Pin)
-	cRep := webDb.Cmd("get", u.Uname+"Cookie")
-	cFound, err := cRep.Bool()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if cFound {
-		fmt.Println("found")
-		c, err := r.Cookie(u.Uname)
-		if err == nil {
-			fmt.Println("gotCookie")
-			cVal, err := cRep.Bytes()
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			if c.Value == string(cVal) {
-				fmt.Println("valEqual")
-				u.Cookie = true
-			}
-		}
-	}
-	if u.Cookie {
-		t.Execute(w, u)
-	} else {
-		http.Redirect(w, r, "/register/", http.StatusFound)
+	identities, err := userIdentities(uname)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-}
-
-func makeCookie(uname string) http.Cookie {
-	expire := time.Now().AddDate(0, 0, 1)
-	cookieBytes := make([]byte, 64)
-	crand.Read(cookieBytes)
-	cookieString := hex.EncodeToString(cookieBytes)
-	fmt.Println("crandom string:", cookieString)
-	userCookie := http.Cookie{uname, cookieString, "/", "anex.us", expire, expire.Format(time.UnixDate),
-		86400, true, false, uname + "=" + cookieString, []string{uname + "=" + cookieString}}
-	webDb.Cmd("set", uname+"Cookie", cookieString) //this overwrites an existing cookie
-	webDb.Cmd("expire", uname+"Cookie", 86400)
-	return userCookie
+	render(w, r, "user.html", User{uname, pin, expires, identities}, http.StatusOK)
 }
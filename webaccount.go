@@ -0,0 +1,134 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+//accountAction returns the user posting r from their account page. If nobody is logged in or the form is missing its
+//CSRF token, the browser is redirected and "" is returned.
+func accountAction(w http.ResponseWriter, r *http.Request) string {
+	uname := webUser(r)
+	if uname == "" {
+		http.Redirect(w, r, "/login/", http.StatusSeeOther)
+		return ""
+	}
+	if !checkCSRF(r) {
+		setFlash(w, formExpired)
+		http.Redirect(w, r, "/user/", http.StatusSeeOther)
+		return ""
+	}
+	return uname
+}
+
+//unlinkHandler removes one of the user's IRC identities, undoing the link between nicks verifying it made
+func unlinkHandler(w http.ResponseWriter, r *http.Request) {
+	uname := accountAction(w, r)
+	if uname == "" {
+		return
+	}
+	identities, err := userIdentities(uname)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, id := range identities {
+		if id.ID() == r.PostFormValue("identity") && id.Linked && id.Network == network() {
+			if _, err := unlinkNick(id.Nick); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	if err := removeIdentity(uname, r.PostFormValue("identity")); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setFlash(w, "The identity has been unlinked.")
+	http.Redirect(w, r, "/user/", http.StatusSeeOther)
+}
+
+//newPinHandler gives the user a new verification PIN
+func newPinHandler(w http.ResponseWriter, r *http.Request) {
+	uname := accountAction(w, r)
+	if uname == "" {
+		return
+	}
+	if _, _, err := newUserPin(uname); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setFlash(w, "You have a new PIN.")
+	http.Redirect(w, r, "/user/", http.StatusSeeOther)
+}
+
+//passwordHandler changes the user's password, logging out their other sessions
+func passwordHandler(w http.ResponseWriter, r *http.Request) {
+	uname := accountAction(w, r)
+	if uname == "" {
+		return
+	}
+	pwdMatch, err := checkPassword(uname, r.PostFormValue("current"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !pwdMatch {
+		setFlash(w, "Your current password is wrong.")
+		http.Redirect(w, r, "/user/", http.StatusSeeOther)
+		return
+	}
+	pwd := r.PostFormValue("pwd")
+	if problem := validatePassword(uname, pwd, r.PostFormValue("confirm")); problem != "" {
+		setFlash(w, problem)
+		http.Redirect(w, r, "/user/", http.StatusSeeOther)
+		return
+	}
+	hash, err := hashPassword(pwd)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := db.Set(userKey(uname, "password"), hash, 0); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s, _ := getSession(r)
+	if err := endUserSessions(uname); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := startSession(w, r, uname, s.Remember); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Println("Web user", uname, "changed their password from", clientIP(r))
+	setFlash(w, "Your password has been changed and your other sessions logged out.")
+	http.Redirect(w, r, "/user/", http.StatusSeeOther)
+}
+
+//deleteAccountHandler deletes the user's account once they've confirmed it with their password
+func deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	uname := accountAction(w, r)
+	if uname == "" {
+		return
+	}
+	pwdMatch, err := checkPassword(uname, r.PostFormValue("pwd"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !pwdMatch {
+		setFlash(w, "Wrong password, your account wasn't deleted.")
+		http.Redirect(w, r, "/user/", http.StatusSeeOther)
+		return
+	}
+	if err := deleteUser(uname); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	endSession(w, r)
+	log.Println("Web user", uname, "deleted their account from", clientIP(r))
+	setFlash(w, "Your account has been deleted.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestUnlinkUndoesNickLink(t *testing.T) {
+	db = newMemoryStore()
+	config.Network = "testnet"
+	if created, err := createUser("alice", "correct horse"); !created || err != nil {
+		t.Fatalf("createUser = %v, %v", created, err)
+	}
+	srvChan := make(chan string, 1)
+	for _, nick := range []string{"alice", "alice_"} {
+		pin, _, err := userPin("alice")
+		if err != nil || pin == "" {
+			t.Fatalf("userPin = %q, %v", pin, err)
+		}
+		verify(srvChan, nick, nick, "al", "example.org", []string{"alice", pin})
+		<-srvChan
+	}
+	if personOf("alice_") != personOf("alice") {
+		t.Fatal("verifying a second nick didn't link it to the first")
+	}
+	identities, err := userIdentities("alice")
+	if err != nil || len(identities) != 2 {
+		t.Fatalf("userIdentities = %v, %v", identities, err)
+	}
+	var linked identity
+	for _, id := range identities {
+		if id.Linked {
+			linked = id
+		}
+	}
+	if linked.Nick != "alice_" {
+		t.Fatalf("linked identity is %+v, want alice_", linked)
+	}
+
+	login := httptest.NewRecorder()
+	if err := startSession(login, httptest.NewRequest("POST", "/login/", nil), "alice", false); err != nil {
+		t.Fatal(err)
+	}
+	cookie := login.Result().Cookies()[0]
+	csrf, _, _ := db.HGet(sessionKey(cookie.Value), "csrf")
+	form := url.Values{"identity": {linked.ID()}, "csrf": {csrf}}
+	r := httptest.NewRequest("POST", "/user/unlink/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	unlinkHandler(w, r)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("unlink responded %d", w.Code)
+	}
+
+	if identities, _ := userIdentities("alice"); len(identities) != 1 || identities[0].Nick != "alice" {
+		t.Fatalf("identities after unlinking = %+v", identities)
+	}
+	if personOf("alice_") == personOf("alice") {
+		t.Fatal("unlinking the identity left its nick linked")
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//logDayIndex records where each day starts in a channel log, so single days can be read without parsing the whole log
+type logDayIndex struct {
+	size    int64            //bytes of the log indexed so far
+	day     time.Time        //current day at the end of the indexed part, for irssi logs
+	days    []string         //days with events, oldest first
+	offsets map[string]int64 //day -> offset of its first event
+}
+
+//logLine is a log line prepared for display
+type logLine struct {
+	Anchor string
+	Time   string
+	Nick   string
+	Colour int
+	Type   string
+	HTML   template.HTML
+}
+
+//logsPage is passed to logs.html, logdays.html and logday.html
+type logsPage struct {
+	Channels []string
+	Channel  string
+	Days     []string
+	Day      string
+	PrevDay  string
+	NextDay  string
+	Lines    []logLine
+}
+
+var (
+	dayIndexMutex sync.Mutex
+	dayIndexes    = make(map[string]*logDayIndex) //log path -> index
+)
+
+//indexDays brings the day index of the log at path up to date with what has been written since it was last indexed
+func indexDays(path string) (*logDayIndex, error) {
+	dayIndexMutex.Lock()
+	defer dayIndexMutex.Unlock()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	index, found := dayIndexes[path]
+	if !found || stat.Size() < index.size { //new or truncated log
+		index = &logDayIndex{offsets: make(map[string]int64)}
+		dayIndexes[path] = index
+	}
+	if _, err := file.Seek(index.size, 0); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(file)
+	offset := index.size
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil { //EOF, or a line still being written
+			break
+		}
+		if event, ok := parseLogLine(strings.TrimRight(line, "\r\n"), &index.day, time.Local); ok {
+			day := event.Time.Format("2006-01-02")
+			if _, seen := index.offsets[day]; !seen {
+				index.offsets[day] = offset
+				index.days = append(index.days, day)
+			}
+		}
+		offset += int64(len(line))
+	}
+	index.size = offset
+	return index, nil
+}
+
+//readDay returns the events of day (formatted 2006-01-02) from the log at path
+func readDay(path, day string) ([]logEvent, error) {
+	index, err := indexDays(path)
+	if err != nil {
+		return nil, err
+	}
+	offset, found := index.offsets[day]
+	if !found {
+		return nil, nil
+	}
+	dayTime, err := time.ParseInLocation("2006-01-02", day, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, 0); err != nil {
+		return nil, err
+	}
+	var events []logEvent
+	scanner := bufio.NewScanner(io.LimitReader(file, index.size-offset))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	current := dayTime
+	for scanner.Scan() {
+		event, ok := parseLogLine(scanner.Text(), &current, time.Local)
+		if !ok {
+			continue
+		}
+		if event.Time.Format("2006-01-02") != day {
+			break
+		}
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
+
+//loggedChannels returns the channels of the current network that have logs
+func loggedChannels() []string {
+	files, err := ioutil.ReadDir(logDir())
+	if err != nil {
+		return nil
+	}
+	var channels []string
+	for _, file := range files {
+		if name := file.Name(); strings.HasSuffix(name, ".log") && isChannel(name) {
+			channels = append(channels, strings.TrimSuffix(name, ".log"))
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+//canView reports whether the web user making r may read channel's logs. Logs of private channels may only be read by
+//users with a verified IRC identity that is currently in the channel.
+func canView(r *http.Request, channel string) bool {
+	if !channelConfig(channel).Private {
+		return true
+	}
+	uname := webUser(r)
+	if uname == "" {
+		return false
+	}
+	identities, err := userIdentities(uname)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	for _, id := range identities {
+		if id.Network == network() && isMember(channel, id.Nick, id.User+"@"+id.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+//nickColour picks one of 16 colours for nick, the same every time
+func nickColour(nick string) int {
+	sum := 0
+	for _, c := range []byte(ircLower(nick)) {
+		sum = sum*31 + int(c)
+	}
+	if sum < 0 {
+		sum = -sum
+	}
+	return sum % 16
+}
+
+//ircToHTML escapes text and renders its IRC formatting codes (bold, italic, underline, strikethrough, monospace,
+//reverse and colours) as spans with the classes defined in resources/logStyle.css
+func ircToHTML(text string) template.HTML {
+	var out strings.Builder
+	var bold, italic, underline, strike, mono, reverse bool
+	fg, bg := -1, -1
+	var pending strings.Builder //text waiting to be written in the current style
+
+	flush := func() {
+		if pending.Len() == 0 {
+			return
+		}
+		var classes []string
+		if bold {
+			classes = append(classes, "b")
+		}
+		if italic {
+			classes = append(classes, "i")
+		}
+		if underline {
+			classes = append(classes, "u")
+		}
+		if strike {
+			classes = append(classes, "s")
+		}
+		if mono {
+			classes = append(classes, "m")
+		}
+		textFg, textBg := fg, bg
+		if reverse {
+			textFg, textBg = bg, fg
+			if textFg < 0 {
+				textFg = 0
+			}
+			if textBg < 0 {
+				textBg = 1
+			}
+		}
+		if textFg >= 0 {
+			classes = append(classes, "fg"+strconv.Itoa(textFg))
+		}
+		if textBg >= 0 {
+			classes = append(classes, "bg"+strconv.Itoa(textBg))
+		}
+		if len(classes) > 0 {
+			out.WriteString(`<span class="` + strings.Join(classes, " ") + `">` +
+				template.HTMLEscapeString(pending.String()) + "</span>")
+		} else {
+			out.WriteString(template.HTMLEscapeString(pending.String()))
+		}
+		pending.Reset()
+	}
+
+	//readNumber reads a colour number of up to two digits at text[i:], returning it and the index after it
+	readNumber := func(i int) (int, int) {
+		n, j := 0, i
+		for j < len(text) && j < i+2 && text[j] >= '0' && text[j] <= '9' {
+			n = n*10 + int(text[j]-'0')
+			j++
+		}
+		if j == i {
+			return -1, i
+		}
+		return n % 16, j
+	}
+
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\x02':
+			flush()
+			bold = !bold
+		case '\x1d':
+			flush()
+			italic = !italic
+		case '\x1f':
+			flush()
+			underline = !underline
+		case '\x1e':
+			flush()
+			strike = !strike
+		case '\x11':
+			flush()
+			mono = !mono
+		case '\x16':
+			flush()
+			reverse = !reverse
+		case '\x0f':
+			flush()
+			bold, italic, underline, strike, mono, reverse = false, false, false, false, false, false
+			fg, bg = -1, -1
+		case '\x03':
+			flush()
+			newFg, j := readNumber(i + 1)
+			if newFg < 0 { //bare \x03 resets colours
+				fg, bg = -1, -1
+				continue
+			}
+			fg = newFg
+			if j+1 < len(text) && text[j] == ',' {
+				if newBg, k := readNumber(j + 1); newBg >= 0 {
+					bg, j = newBg, k
+				}
+			}
+			i = j - 1
+		default:
+			pending.WriteByte(text[i])
+		}
+	}
+	flush()
+	return template.HTML(out.String())
+}
+
+//describeEvent returns the text shown for event, without its time and nick
+func describeEvent(event logEvent) template.HTML {
+	switch event.Type {
+	case "privmsg", "action", "notice":
+		return ircToHTML(event.Text)
+	case "join":
+		return template.HTML(template.HTMLEscapeString("[" + event.UserHost + "] has joined"))
+	case "part":
+		return template.HTML(template.HTMLEscapeString("[" + event.UserHost + "] has left [" + event.Text + "]"))
+	case "quit":
+		return template.HTML(template.HTMLEscapeString("[" + event.UserHost + "] has quit [" + event.Text + "]"))
+	case "nick":
+		return template.HTML(template.HTMLEscapeString("is now known as " + event.Target))
+	case "kick":
+		return template.HTML(template.HTMLEscapeString("kicked " + event.Target + " [" + event.Text + "]"))
+	case "topic":
+		return "changed the topic to: " + ircToHTML(event.Text)
+	}
+	return ""
+}
+
+//logsHandler serves /logs/ (list of channels), /logs/<channel>/ (list of days) and /logs/<channel>/<day> (the log of a
+//single day). Channel names are escaped in URLs, so #chan is /logs/%23chan/.
+func logsHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path[len("/logs/"):], "/")
+	page := logsPage{}
+	templateFile := "logs.html"
+	if path == "" {
+		for _, channel := range loggedChannels() {
+			if canView(r, channel) {
+				page.Channels = append(page.Channels, channel)
+			}
+		}
+	} else {
+		parts := strings.SplitN(path, "/", 2)
+		page.Channel = parts[0]
+		if !isChannel(page.Channel) {
+			http.NotFound(w, r)
+			return
+		}
+		if !canView(r, page.Channel) {
+			http.Error(w, "This channel's logs are private", http.StatusForbidden)
+			return
+		}
+		index, err := indexDays(logPath(page.Channel))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if len(parts) == 1 {
+			templateFile = "logdays.html"
+			page.Days = make([]string, len(index.days))
+			for i, day := range index.days { //newest first
+				page.Days[len(index.days)-1-i] = day
+			}
+		} else {
+			templateFile = "logday.html"
+			page.Day = parts[1]
+			events, err := readDay(logPath(page.Channel), page.Day)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if events == nil {
+				http.NotFound(w, r)
+				return
+			}
+			for i, day := range index.days {
+				if day == page.Day {
+					if i > 0 {
+						page.PrevDay = index.days[i-1]
+					}
+					if i+1 < len(index.days) {
+						page.NextDay = index.days[i+1]
+					}
+				}
+			}
+			for i, event := range events {
+				page.Lines = append(page.Lines, logLine{
+					Anchor: fmt.Sprintf("L%d", i+1),
+					Time:   event.Time.Format("15:04"),
+					Nick:   event.Nick,
+					Colour: nickColour(event.Nick),
+					Type:   event.Type,
+					HTML:   describeEvent(event),
+				})
+			}
+		}
+	}
+	render(w, r, templateFile, page, http.StatusOK)
+}
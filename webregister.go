@@ -0,0 +1,154 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//registerPage is what register.html is rendered with
+type registerPage struct {
+	Username string
+}
+
+//usernameRegexp matches the usernames that may be registered. They end up in URLs and cookie names, so they're kept to
+//characters that are safe in both.
+var usernameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{2,32}$`)
+
+var (
+	registerMutex sync.Mutex
+	registrations = make(map[string][]time.Time) //client IP -> times of its registrations within the last hour
+)
+
+//clientIP returns the address r came from, without its port. Behind a trusted reverse proxy that's the address the
+//proxy added to X-Forwarded-For.
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); config.Web.TrustProxy && forwarded != "" {
+		addresses := strings.Split(forwarded, ",")
+		return strings.TrimSpace(addresses[len(addresses)-1])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+//reserveRegistration counts a registration from ip now, before the account is created, so concurrent requests can't
+//all get past the limit. It returns the time the registration is counted at, with ok false if ip has registered too
+//many accounts already. Registrations over an hour old are forgotten, from every address.
+func reserveRegistration(ip string) (reserved time.Time, ok bool) {
+	registerMutex.Lock()
+	defer registerMutex.Unlock()
+	now := time.Now()
+	hourAgo := now.Add(-time.Hour)
+	for other, times := range registrations {
+		var recent []time.Time
+		for _, t := range times {
+			if t.After(hourAgo) {
+				recent = append(recent, t)
+			}
+		}
+		if len(recent) == 0 {
+			delete(registrations, other)
+		} else {
+			registrations[other] = recent
+		}
+	}
+	if len(registrations[ip]) >= withDefault(config.Web.RegistrationsPerHour, 3) {
+		return time.Time{}, false
+	}
+	registrations[ip] = append(registrations[ip], now)
+	return now, true
+}
+
+//releaseRegistration stops counting the registration reserved from ip at reserved, when no account was created
+func releaseRegistration(ip string, reserved time.Time) {
+	registerMutex.Lock()
+	defer registerMutex.Unlock()
+	times := registrations[ip]
+	for i, t := range times {
+		if t.Equal(reserved) {
+			registrations[ip] = append(times[:i:i], times[i+1:]...)
+			break
+		}
+	}
+	if len(registrations[ip]) == 0 {
+		delete(registrations, ip)
+	}
+}
+
+//validateRegistration returns why uname and pwd (with confirm, its repetition) can't be registered, or "" if they can
+func validateRegistration(uname, pwd, confirm string) string {
+	if !usernameRegexp.MatchString(uname) {
+		return "Usernames are 2 to 32 letters, digits, underscores or dashes."
+	}
+	return validatePassword(uname, pwd, confirm)
+}
+
+//validatePassword returns why pwd (with confirm, its repetition) can't be user uname's password, or "" if it can
+func validatePassword(uname, pwd, confirm string) string {
+	minLength := withDefault(config.Web.MinPasswordLength, 8)
+	switch {
+	case len([]rune(pwd)) < minLength:
+		return "Passwords must be at least " + strconv.Itoa(minLength) + " characters long."
+	case len(pwd) > 72: //bcrypt ignores anything longer
+		return "Passwords can't be longer than 72 bytes."
+	case strings.EqualFold(pwd, uname):
+		return "Your password can't be your username."
+	case pwd != confirm:
+		return "The passwords don't match."
+	}
+	return ""
+}
+
+func registerHandler(w http.ResponseWriter, r *http.Request) {
+	render(w, r, "register.html", registerPage{}, http.StatusOK)
+}
+
+func saveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Redirect(w, r, "/register/", http.StatusFound)
+		return
+	}
+	uname := strings.TrimSpace(r.FormValue("username"))
+	page := registerPage{Username: uname}
+	if !checkCSRF(r) {
+		render(w, r, "register.html", page, http.StatusForbidden, formExpired)
+		return
+	}
+	if problem := validateRegistration(uname, r.FormValue("pwd"), r.FormValue("confirm")); problem != "" {
+		render(w, r, "register.html", page, http.StatusBadRequest, problem)
+		return
+	}
+	ip := clientIP(r)
+	reserved, ok := reserveRegistration(ip)
+	if !ok {
+		render(w, r, "register.html", page, http.StatusTooManyRequests,
+			"Too many accounts have been registered from your address, try again later.")
+		return
+	}
+	created, err := createUser(uname, r.FormValue("pwd"))
+	if err != nil {
+		releaseRegistration(ip, reserved)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !created {
+		releaseRegistration(ip, reserved)
+		render(w, r, "register.html", page, http.StatusConflict, "That username is taken.")
+		return
+	}
+	log.Println("Registered web user", uname, "from", ip)
+	if err := startSession(w, r, uname, false); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setFlash(w, "Welcome, "+uname+"! Verify your IRC nick by sending the bot: verify "+uname+" <PIN>")
+	http.Redirect(w, r, "/user/", http.StatusSeeOther)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//barEntry is one bar of a bar chart on the stats page
+type barEntry struct {
+	Label   string
+	Count   int
+	Percent int //of the largest bar
+}
+
+//nickStats holds the statistics of a single nick
+type nickStats struct {
+	Nick     string
+	Colour   int
+	Lines    int
+	Words    int
+	LastSeen time.Time
+	Quote    string
+	quotes   int //lines considered for Quote so far
+}
+
+//wordCount is the number of times a word was used
+type wordCount struct {
+	Word  string
+	Count int
+	Last  string //nick who last used it
+}
+
+//quotedLine is a line quoted on the stats page
+type quotedLine struct {
+	Time time.Time
+	Nick string
+	Text string
+}
+
+//urlCount is the number of times a URL was posted
+type urlCount struct {
+	URL   string
+	Count int
+	Last  string //nick who last posted it
+}
+
+//channelStats is passed to stats.html
+type channelStats struct {
+	Channel      string
+	Generated    time.Time
+	Lines        int
+	Nicks        []*nickStats
+	Hours        []barEntry
+	Weekdays     []barEntry
+	Words        []wordCount
+	LongestLines []quotedLine
+	URLs         []urlCount
+	Topics       []quotedLine
+}
+
+//cachedStats is the last statistics computed for a channel
+type cachedStats struct {
+	size  int64
+	stats *channelStats
+}
+
+var (
+	statsMutex sync.Mutex
+	statsCache = make(map[string]*cachedStats) //log path -> statistics
+	urlRegex   = regexp.MustCompile(`https?://[^\s<>"]+`)
+)
+
+//bars turns counts into bar chart entries labelled by labels
+func bars(counts []int, labels []string) []barEntry {
+	maxCount := 1
+	for _, count := range counts {
+		if count > maxCount {
+			maxCount = count
+		}
+	}
+	entries := make([]barEntry, len(counts))
+	for i, count := range counts {
+		entries[i] = barEntry{labels[i], count, count * 100 / maxCount}
+	}
+	return entries
+}
+
+//computeStats reads channel's whole log and computes its statistics
+func computeStats(channel string) (*channelStats, error) {
+	var hours [24]int
+	var weekdays [7]int
+	nicks := make(map[string]*nickStats)
+	words := make(map[string]*wordCount)
+	urls := make(map[string]*urlCount)
+	var longest, topics []quotedLine
+	stats := &channelStats{Channel: channel, Generated: time.Now()}
+
+	err := readLog(channel, func(event logEvent) {
+		if event.Type == "topic" {
+			topics = append(topics, quotedLine{event.Time, event.Nick, event.Text})
+			return
+		}
+		if event.Type != "privmsg" && event.Type != "action" {
+			return
+		}
+		stats.Lines++
+		hours[event.Time.Hour()]++
+		weekdays[event.Time.Weekday()]++
+
+		key := ircLower(event.Nick)
+		nick, found := nicks[key]
+		if !found {
+			nick = &nickStats{Colour: nickColour(event.Nick)}
+			nicks[key] = nick
+		}
+		nick.Nick = event.Nick //most recently used capitalization
+		nick.Lines++
+		nick.LastSeen = event.Time
+		fields := strings.Fields(event.Text)
+		nick.Words += len(fields)
+		if length := len(event.Text); length >= 20 && length <= 200 { //pick a quote by reservoir sampling
+			nick.quotes++
+			if rand.Intn(nick.quotes) == 0 {
+				nick.Quote = event.Text
+			}
+		}
+
+		for _, word := range searchTerms(event.Text) {
+			if len(word) < 5 {
+				continue
+			}
+			count, found := words[word]
+			if !found {
+				count = &wordCount{Word: word}
+				words[word] = count
+			}
+			count.Count++
+			count.Last = event.Nick
+		}
+		for _, link := range urlRegex.FindAllString(event.Text, -1) {
+			count, found := urls[link]
+			if !found {
+				count = &urlCount{URL: link}
+				urls[link] = count
+			}
+			count.Count++
+			count.Last = event.Nick
+		}
+
+		line := quotedLine{event.Time, event.Nick, event.Text}
+		if len(longest) < 5 || len(line.Text) > len(longest[len(longest)-1].Text) {
+			longest = append(longest, line)
+			sort.SliceStable(longest, func(i, j int) bool { return len(longest[i].Text) > len(longest[j].Text) })
+			if len(longest) > 5 {
+				longest = longest[:5]
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hourLabels := make([]string, 24)
+	for i := range hourLabels {
+		hourLabels[i] = time.Date(0, 1, 1, i, 0, 0, 0, time.UTC).Format("15")
+	}
+	stats.Hours = bars(hours[:], hourLabels)
+	weekdayLabels := make([]string, 7)
+	for i := range weekdayLabels {
+		weekdayLabels[i] = time.Weekday(i).String()
+	}
+	stats.Weekdays = bars(weekdays[:], weekdayLabels)
+
+	for key, nick := range nicks {
+		if key == ircLower(config.Nick) {
+			continue
+		}
+		stats.Nicks = append(stats.Nicks, nick)
+	}
+	sort.Slice(stats.Nicks, func(i, j int) bool { return stats.Nicks[i].Lines > stats.Nicks[j].Lines })
+	if len(stats.Nicks) > 25 {
+		stats.Nicks = stats.Nicks[:25]
+	}
+
+	for word, count := range words {
+		if _, isNick := nicks[word]; !isNick {
+			stats.Words = append(stats.Words, *count)
+		}
+	}
+	sort.Slice(stats.Words, func(i, j int) bool { return stats.Words[i].Count > stats.Words[j].Count })
+	if len(stats.Words) > 20 {
+		stats.Words = stats.Words[:20]
+	}
+
+	for _, count := range urls {
+		stats.URLs = append(stats.URLs, *count)
+	}
+	sort.Slice(stats.URLs, func(i, j int) bool { return stats.URLs[i].Count > stats.URLs[j].Count })
+	if len(stats.URLs) > 10 {
+		stats.URLs = stats.URLs[:10]
+	}
+
+	stats.LongestLines = longest
+	for i := len(topics) - 1; i >= 0 && len(stats.Topics) < 10; i-- { //newest first
+		stats.Topics = append(stats.Topics, topics[i])
+	}
+	return stats, nil
+}
+
+//channelStatistics returns the statistics of channel, recomputing them if the log has grown and they are more than
+//ten minutes old
+func channelStatistics(channel string) (*channelStats, error) {
+	path := logPath(channel)
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	cached, found := statsCache[path]
+	if found && (cached.size == stat.Size() || time.Since(cached.stats.Generated) < 10*time.Minute) {
+		return cached.stats, nil
+	}
+	stats, err := computeStats(channel)
+	if err != nil {
+		return nil, err
+	}
+	statsCache[path] = &cachedStats{stat.Size(), stats}
+	return stats, nil
+}
+
+//statsHandler serves /stats/<channel>/, with channel escaped like in /logs/
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	channel := strings.Trim(r.URL.Path[len("/stats/"):], "/")
+	if !isChannel(channel) {
+		http.NotFound(w, r)
+		return
+	}
+	if !canView(r, channel) {
+		http.Error(w, "This channel's logs are private", http.StatusForbidden)
+		return
+	}
+	stats, err := channelStatistics(channel)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	render(w, r, "stats.html", stats, http.StatusOK)
+}
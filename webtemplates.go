@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+//embeddedFiles holds the templates and resources built into the binary
+//
+//go:embed *.html resources
+var embeddedFiles embed.FS
+
+const flashCookie = "yaircb_flash" //holds a message to show on the next page rendered
+
+//webFiles returns the templates and resources, read from Web.TemplateDir if it's set so they can be edited without
+//rebuilding the bot
+func webFiles() fs.FS {
+	if config.Web.TemplateDir != "" {
+		return os.DirFS(config.Web.TemplateDir)
+	}
+	return embeddedFiles
+}
+
+//resourceFiles returns the files served under /resources/
+func resourceFiles() fs.FS {
+	resources, err := fs.Sub(webFiles(), "resources")
+	if err != nil {
+		log.Fatal(err)
+	}
+	return resources
+}
+
+//templateFuncs are available to every template. webUser, csrf and flashes are replaced for each request by render.
+var templateFuncs = template.FuncMap{
+	"pathEscape": url.PathEscape,
+	"inc":        func(i int) int { return i + 1 },
+	"webUser":    func() string { return "" },
+	"csrf":       func() string { return "" },
+	"flashes":    func() []string { return nil },
+}
+
+var (
+	templateMutex sync.Mutex
+	templateCache = make(map[string]*template.Template) //page -> page parsed with layout.html
+)
+
+//parseTemplate returns page parsed along with layout.html. Templates are only parsed once unless they're read from
+//disk, in which case they're parsed every time so changes show up straight away.
+func parseTemplate(page string) (*template.Template, error) {
+	if config.Web.TemplateDir != "" {
+		return template.New(page).Funcs(templateFuncs).ParseFS(webFiles(), "layout.html", page)
+	}
+	templateMutex.Lock()
+	defer templateMutex.Unlock()
+	if t, found := templateCache[page]; found {
+		return t, nil
+	}
+	t, err := template.New(page).Funcs(templateFuncs).ParseFS(webFiles(), "layout.html", page)
+	if err != nil {
+		return nil, err
+	}
+	templateCache[page] = t
+	return t, nil
+}
+
+//setFlash leaves message to be shown on the next page r's browser is sent
+func setFlash(w http.ResponseWriter, message string) {
+	http.SetCookie(w, newCookie(flashCookie, url.QueryEscape(message), time.Minute))
+}
+
+//takeFlashes returns the message left for r's browser, if any, and clears it
+func takeFlashes(w http.ResponseWriter, r *http.Request) []string {
+	c, err := r.Cookie(flashCookie)
+	if err != nil {
+		return nil
+	}
+	http.SetCookie(w, newCookie(flashCookie, "", -time.Second))
+	message, err := url.QueryUnescape(c.Value)
+	if err != nil || message == "" {
+		return nil
+	}
+	return []string{message}
+}
+
+//render writes page with data inside the shared layout, using status as the response code. flashes are shown along
+//with any message left by an earlier request.
+func render(w http.ResponseWriter, r *http.Request, page string, data interface{}, status int, flashes ...string) {
+	t, err := parseTemplate(page)
+	if err == nil {
+		t, err = t.Clone()
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Can't load page template", http.StatusInternalServerError)
+		return
+	}
+	user := webUser(r)
+	csrf := csrfToken(w, r)
+	flashes = append(takeFlashes(w, r), flashes...)
+	t.Funcs(template.FuncMap{
+		"webUser": func() string { return user },
+		"csrf":    func() string { return csrf },
+		"flashes": func() []string { return flashes },
+	})
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, "layout.html", data); err != nil {
+		log.Println(err)
+		http.Error(w, "Can't render page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}